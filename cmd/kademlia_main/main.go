@@ -8,6 +8,7 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 )
 
 var (
@@ -16,6 +17,34 @@ var (
 	BootstrapNodeAddress = os.Getenv("BOOTSTRAP_IP")
 	BootstrapNodePort, _ = strconv.Atoi(os.Getenv("BOOTSTRAP_PORT"))
 	BootstrapNodeId      = os.Getenv("BOOTSTRAP_ID")
+
+	// Network configuration
+	IDLength, _          = strconv.Atoi(os.Getenv("B"))
+	NetworkID            = os.Getenv("NETWORK_ID")
+	ClusterKey           = os.Getenv("CLUSTER_KEY")
+	Encryption, _        = strconv.ParseBool(os.Getenv("ENCRYPTION"))
+	Interface            = os.Getenv("INTERFACE")
+	PreferIPv6, _        = strconv.ParseBool(os.Getenv("PREFER_IPV6"))
+	DualStack, _         = strconv.ParseBool(os.Getenv("DUAL_STACK"))
+	FilterUnreachable, _ = strconv.ParseBool(os.Getenv("FILTER_UNREACHABLE"))
+	Compression, _       = strconv.ParseBool(os.Getenv("COMPRESSION"))
+	Retries              = os.Getenv("RETRIES")
+
+	// Routing table configuration
+	BucketSplitting, _    = strconv.ParseBool(os.Getenv("BUCKET_SPLITTING"))
+	RelaxedSplitting, _   = strconv.ParseBool(os.Getenv("RELAXED_SPLITTING"))
+	DigitBits, _          = strconv.Atoi(os.Getenv("DIGIT_BITS"))
+	ProximitySelection, _ = strconv.ParseBool(os.Getenv("PROXIMITY_SELECTION"))
+	LookupRTTTieBreak, _  = strconv.ParseBool(os.Getenv("LOOKUP_RTT_TIE_BREAK"))
+	SnapshotFile          = os.Getenv("SNAPSHOT_FILE")
+	FailureThreshold, _   = strconv.Atoi(os.Getenv("FAILURE_THRESHOLD"))
+	StatsInterval, _      = time.ParseDuration(os.Getenv("STATS_INTERVAL"))
+
+	// Limits on contacts from the same host or network
+	MaxSameIPPerBucket, _     = strconv.Atoi(os.Getenv("MAX_SAME_IP_PER_BUCKET"))
+	MaxSameSubnetPerBucket, _ = strconv.Atoi(os.Getenv("MAX_SAME_SUBNET_PER_BUCKET"))
+	MaxSameIPPerTable, _      = strconv.Atoi(os.Getenv("MAX_SAME_IP_PER_TABLE"))
+	MaxSameSubnetPerTable, _  = strconv.Atoi(os.Getenv("MAX_SAME_SUBNET_PER_TABLE"))
 )
 
 func main() {
@@ -24,20 +53,129 @@ func main() {
 	var wg sync.WaitGroup
 	wg.Add(2)
 
+	// Use the ID length of the network, all nodes must agree on it
+	if IDLength != 0 {
+		if err := kademlia.SetIDLength(IDLength); err != nil {
+			fmt.Println("Error setting ID length:", err)
+			os.Exit(1)
+		}
+	}
+	// Only talk to nodes in the same network
+	if NetworkID != "" {
+		kademlia.NetworkID = NetworkID
+	}
+	// Only accept messages authenticated with the cluster key
+	if ClusterKey != "" {
+		kademlia.ClusterKey = []byte(ClusterKey)
+	}
+	// Encrypt messages to nodes that advertise a public key
+	kademlia.Encryption = Encryption
+	// Choose the address to advertise and listen on
+	if Interface != "" {
+		kademlia.InterfaceName = Interface
+	}
+	kademlia.PreferIPv6 = PreferIPv6
+	kademlia.DualStack = DualStack
+	// Skip contacts of the other IP family, peers only advertise one address
+	kademlia.FilterUnreachable = FilterUnreachable
+	// Compress large messages to nodes that support it
+	kademlia.Compression = Compression
+	// Resend requests that timed out, responses to duplicates are answered from the response cache
+	if retries, err := strconv.Atoi(Retries); err == nil && retries >= 0 {
+		kademlia.Retries = retries
+	}
+	// Start with one bucket and split it instead of allocating all buckets
+	kademlia.BucketSplitting = BucketSplitting
+	kademlia.RelaxedSplitting = RelaxedSplitting
+	// Group IDs into digits of this many bits for lookups with fewer hops
+	if DigitBits != 0 {
+		if err := kademlia.SetDigitBits(DigitBits); err != nil {
+			fmt.Println("Error setting digit bits:", err)
+			os.Exit(1)
+		}
+	}
+	// Prefer contacts with lower round trip times in buckets and lookups
+	kademlia.ProximitySelection = ProximitySelection
+	kademlia.LookupRTTTieBreak = LookupRTTTieBreak
+	// Evict contacts after this many consecutive failed requests
+	if FailureThreshold > 0 {
+		kademlia.FailureThreshold = FailureThreshold
+	}
+	// Limit how many contacts a single host or network may hold
+	kademlia.MaxSameIPPerBucket = MaxSameIPPerBucket
+	kademlia.MaxSameSubnetPerBucket = MaxSameSubnetPerBucket
+	kademlia.MaxSameIPPerTable = MaxSameIPPerTable
+	kademlia.MaxSameSubnetPerTable = MaxSameSubnetPerTable
+
+	// Log the state of the routing table periodically
+	kademlia.StatsInterval = StatsInterval
+
+	// Load the routing table saved by a previous run
+	var snapshot *kademlia.RoutingTableSnapshot
+	if SnapshotFile != "" {
+		var err error
+		snapshot, err = kademlia.LoadRoutingTableSnapshot(SnapshotFile)
+		if err != nil {
+			fmt.Println("No routing table restored:", err)
+		}
+	}
+
 	// Create a bootstrap node and join the network
 	if !isBootstrapNode {
 		bootstrapNode := kademlia.NewContact(
 			kademlia.NewKademliaID(BootstrapNodeId),
 			BootstrapNodeAddress,
 			BootstrapNodePort)
-		node := kademlia.NewNode(kademlia.NewRandomKademliaID())
+		id := kademlia.NewRandomKademliaID()
+		// Keep the ID of the previous run so that the restored contacts still fit
+		if snapshot != nil {
+			id = snapshot.Id
+		}
+		node := kademlia.NewNode(id)
 		go node.Network.Listen()
-		go node.Join(bootstrapNode)
-		fmt.Println("Node id: ", node.Me.Id)
+		go restore(node, snapshot, bootstrapNode)
+		go node.RefreshLoop()
+		if StatsInterval > 0 {
+			go node.StatsLoop()
+		}
+		fmt.Println("Node id: ", node.GetMe().Id)
 	} else {
 		node := kademlia.NewNode(kademlia.NewKademliaID(BootstrapNodeId))
 		go node.Network.Listen()
-		fmt.Println("Node id: ", node.Me.Id)
+		go restore(node, snapshot, nil)
+		go node.RefreshLoop()
+		if StatsInterval > 0 {
+			go node.StatsLoop()
+		}
+		fmt.Println("Node id: ", node.GetMe().Id)
 	}
 	wg.Wait() // Wait indefinitely
 }
+
+// restore adds the contacts of the snapshot to the routing table of the node and
+// starts saving the routing table periodically. The lookup of the node starts
+// from the restored contacts right away while they are verified in the background,
+// and the node joins through the bootstrap node, if any, when none of them answers
+func restore(node *kademlia.Node, snapshot *kademlia.RoutingTableSnapshot, bootstrap *kademlia.Contact) {
+	if SnapshotFile != "" {
+		go node.SnapshotLoop(SnapshotFile)
+	}
+	if snapshot != nil {
+		contacts := node.RestoreRoutingTable(snapshot)
+		fmt.Println("Restored", len(contacts), "contacts from", SnapshotFile)
+		go node.VerifyContacts(contacts)
+	}
+
+	if node.RoutingTable.Size() > 0 {
+		// Find the contacts that joined close to us while we were gone
+		if closest := node.LookupContact(node.GetMe()); len(closest) > 0 {
+			node.RoutingTable.UpdateRoutingTable(closest)
+			return
+		}
+	}
+	if bootstrap != nil {
+		if err := node.Join(bootstrap); err != nil {
+			fmt.Println("Error joining the network:", err)
+		}
+	}
+}
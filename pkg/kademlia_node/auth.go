@@ -0,0 +1,44 @@
+package kademlia_node
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+)
+
+// ClusterKey is the shared secret of a private cluster. When set, every
+// serialized message carries an HMAC over its bytes and messages without
+// a valid HMAC are discarded
+var ClusterKey []byte
+
+// MACLength is the number of bytes appended to authenticated messages
+const MACLength = sha256.Size
+
+// SignMessage appends an HMAC of the data to the data if a cluster key is set
+func SignMessage(data []byte) []byte {
+	if len(ClusterKey) == 0 {
+		return data
+	}
+	mac := hmac.New(sha256.New, ClusterKey)
+	mac.Write(data)
+	return mac.Sum(data)
+}
+
+// VerifyMessage checks the HMAC appended to the data if a cluster key is set
+// and returns the data without the HMAC
+func VerifyMessage(data []byte) ([]byte, error) {
+	if len(ClusterKey) == 0 {
+		return data, nil
+	}
+	if len(data) < MACLength {
+		return nil, fmt.Errorf("unauthenticated message")
+	}
+	message, messageMAC := data[:len(data)-MACLength], data[len(data)-MACLength:]
+
+	mac := hmac.New(sha256.New, ClusterKey)
+	mac.Write(message)
+	if !hmac.Equal(messageMAC, mac.Sum(nil)) {
+		return nil, fmt.Errorf("invalid message authentication code")
+	}
+	return message, nil
+}
@@ -3,25 +3,35 @@ package kademlia_node
 import (
 	"container/list"
 	"fmt"
+	"time"
 )
 
+// ReplacementCacheSize is the number of candidates a full bucket remembers
+// to replace contacts that fail
+var ReplacementCacheSize = 5
+
 // bucket definition
-// contains a List
+// contains a List and a replacement cache of candidates
+// seen while the List was full
 type bucket struct {
-	List *list.List
-	K    int
+	List         *list.List
+	Replacements *list.List
+	K            int
+	LastActivity time.Time // Last lookup in or traffic from the range of the bucket
 }
 
 // NewBucket returns a new instance of a bucket
 func NewBucket(k int) *bucket {
 	bucket := &bucket{}
 	bucket.List = list.New()
+	bucket.Replacements = list.New()
 	bucket.K = k
+	bucket.LastActivity = time.Now()
 	return bucket
 }
 
-// AddContact adds the Contact to the front of the bucket
-// or moves it to the front of the bucket if it already existed
+// AddContact adds the Contact to the front of the bucket or moves it to
+// the front of the bucket with its new address if it already existed
 func (bucket *bucket) AddContact(contact Contact) {
 	var element *list.Element
 	for e := bucket.List.Front(); e != nil; e = e.Next() {
@@ -37,6 +47,14 @@ func (bucket *bucket) AddContact(contact Contact) {
 			bucket.List.PushFront(contact)
 		}
 	} else {
+		// Keep the newest address and key of the contact and what we know about it
+		previous := element.Value.(Contact)
+		contact.Failures = previous.Failures
+		contact.FirstSeen = previous.FirstSeen
+		contact.LastSeen = previous.LastSeen
+		contact.LastRTT = previous.LastRTT
+		contact.SmoothedRTT = previous.SmoothedRTT
+		element.Value = contact
 		bucket.List.MoveToFront(element)
 	}
 }
@@ -51,6 +69,124 @@ func (bucket *bucket) RemoveContact(contact Contact) {
 	}
 }
 
+// RecordFailure increases the consecutive failures of the Contact in the bucket
+// and returns them, or 0 if the Contact is not in the bucket
+func (bucket *bucket) RecordFailure(contact Contact) int {
+	for elt := bucket.List.Front(); elt != nil; elt = elt.Next() {
+		stored := elt.Value.(Contact)
+		if stored.Id.Equals(contact.Id) {
+			stored.Failures++
+			elt.Value = stored
+			return stored.Failures
+		}
+	}
+	return 0
+}
+
+// RecordSuccess marks the Contact in the bucket as seen and clears its consecutive
+// failures. A positive rtt is the round trip time of a request to the Contact
+func (bucket *bucket) RecordSuccess(contact Contact, rtt time.Duration) {
+	for elt := bucket.List.Front(); elt != nil; elt = elt.Next() {
+		stored := elt.Value.(Contact)
+		if stored.Id.Equals(contact.Id) {
+			stored.Failures = 0
+			stored.LastSeen = time.Now()
+			stored.recordRTT(rtt)
+			elt.Value = stored
+			return
+		}
+	}
+}
+
+// RecordReplacementRTT marks the candidate as seen and records the round trip time
+// of a request to it. Returns false if the candidate is not in the replacement cache
+func (bucket *bucket) RecordReplacementRTT(contact Contact, rtt time.Duration) bool {
+	for elt := bucket.Replacements.Front(); elt != nil; elt = elt.Next() {
+		stored := elt.Value.(Contact)
+		if stored.Id.Equals(contact.Id) {
+			stored.LastSeen = time.Now()
+			stored.recordRTT(rtt)
+			elt.Value = stored
+			return true
+		}
+	}
+	return false
+}
+
+// recordRTT adds the round trip time to the smoothed round trip time of the contact
+func (contact *Contact) recordRTT(rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+	// Smoothed as in RFC 6298, like the RTTStats of the network
+	if contact.SmoothedRTT == 0 {
+		contact.SmoothedRTT = rtt
+	} else {
+		contact.SmoothedRTT = (7*contact.SmoothedRTT + rtt) / 8
+	}
+	contact.LastRTT = rtt
+}
+
+// AddReplacement adds the Contact to the front of the replacement cache
+// or moves it to the front if it already existed. The least recently seen
+// candidate is dropped when the cache is full
+func (bucket *bucket) AddReplacement(contact Contact) {
+	for elt := bucket.Replacements.Front(); elt != nil; elt = elt.Next() {
+		if elt.Value.(Contact).Id.Equals(contact.Id) {
+			// Keep the newest address of the candidate and what we know about it
+			previous := elt.Value.(Contact)
+			contact.FirstSeen = previous.FirstSeen
+			contact.LastRTT = previous.LastRTT
+			contact.SmoothedRTT = previous.SmoothedRTT
+			elt.Value = contact
+			bucket.Replacements.MoveToFront(elt)
+			return
+		}
+	}
+	bucket.Replacements.PushFront(contact)
+	if bucket.Replacements.Len() > ReplacementCacheSize {
+		bucket.Replacements.Remove(bucket.Replacements.Back())
+	}
+}
+
+// RemoveReplacement removes the Contact from the replacement cache
+func (bucket *bucket) RemoveReplacement(contact Contact) {
+	for elt := bucket.Replacements.Front(); elt != nil; elt = elt.Next() {
+		if elt.Value.(Contact).Id.Equals(contact.Id) {
+			bucket.Replacements.Remove(elt)
+			break
+		}
+	}
+}
+
+// PromoteReplacement moves the most recently seen candidate the bucket allows,
+// or any candidate if allowed is nil, from the replacement cache into the bucket
+// if there is room for it. Returns false if no candidate was promoted
+func (bucket *bucket) PromoteReplacement(allowed func(bucket *bucket, contact *Contact) bool) (Contact, bool) {
+	if bucket.List.Len() >= bucket.K {
+		return Contact{}, false
+	}
+	for elt := bucket.Replacements.Front(); elt != nil; elt = elt.Next() {
+		contact := elt.Value.(Contact)
+		if allowed == nil || allowed(bucket, &contact) {
+			bucket.Replacements.Remove(elt)
+			bucket.List.PushFront(contact)
+			return contact, true
+		}
+	}
+	return Contact{}, false
+}
+
+// GetReplacements returns a copy of the candidates in the replacement cache,
+// most recently seen first
+func (bucket *bucket) GetReplacements() []Contact {
+	replacements := make([]Contact, 0, bucket.Replacements.Len())
+	for elt := bucket.Replacements.Front(); elt != nil; elt = elt.Next() {
+		replacements = append(replacements, elt.Value.(Contact))
+	}
+	return replacements
+}
+
 // GetContactsAndCalcDistance returns an array of Contacts where
 // the distance has already been calculated
 func (bucket *bucket) GetContactsAndCalcDistance(target *KademliaID) []*Contact {
@@ -76,6 +212,37 @@ func (bucket *bucket) GetLeastRecentlySeenContact() Contact {
 	return bucket.List.Back().Value.(Contact)
 }
 
+// GetSlowestContact returns the contact with the highest smoothed round trip time,
+// or false if no contact in the bucket has a round trip time yet
+func (bucket *bucket) GetSlowestContact() (Contact, bool) {
+	var slowest Contact
+	found := false
+	for elt := bucket.List.Front(); elt != nil; elt = elt.Next() {
+		contact := elt.Value.(Contact)
+		if contact.SmoothedRTT > 0 && (!found || contact.SmoothedRTT > slowest.SmoothedRTT) {
+			slowest = contact
+			found = true
+		}
+	}
+	return slowest, found
+}
+
+// GetFastestReplacement returns the candidate the bucket allows, or any candidate if
+// allowed is nil, with the lowest smoothed round trip time, or false if no such
+// candidate in the replacement cache has a round trip time yet
+func (bucket *bucket) GetFastestReplacement(allowed func(bucket *bucket, contact *Contact) bool) (Contact, bool) {
+	var fastest Contact
+	found := false
+	for elt := bucket.Replacements.Front(); elt != nil; elt = elt.Next() {
+		contact := elt.Value.(Contact)
+		if contact.SmoothedRTT > 0 && (!found || contact.SmoothedRTT < fastest.SmoothedRTT) && (allowed == nil || allowed(bucket, &contact)) {
+			fastest = contact
+			found = true
+		}
+	}
+	return fastest, found
+}
+
 // Contains returns true if the bucket contains the contact
 func (bucket *bucket) Contains(contact Contact) bool {
 	for elt := bucket.List.Front(); elt != nil; elt = elt.Next() {
@@ -91,4 +258,7 @@ func (bucket *bucket) PrintBucket() {
 	for elt := bucket.List.Front(); elt != nil; elt = elt.Next() {
 		fmt.Printf("Bucket element: {Id: %s, Ip: %s, Port: %d, Distance: %s}\n", elt.Value.(Contact).Id, elt.Value.(Contact).Ip, elt.Value.(Contact).Port, elt.Value.(Contact).Distance)
 	}
+	for elt := bucket.Replacements.Front(); elt != nil; elt = elt.Next() {
+		fmt.Printf("Replacement element: {Id: %s, Ip: %s, Port: %d}\n", elt.Value.(Contact).Id, elt.Value.(Contact).Ip, elt.Value.(Contact).Port)
+	}
 }
@@ -0,0 +1,63 @@
+package kademlia_node
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+var (
+	Compression          = false   // Compress large messages to contacts that support it
+	CompressionThreshold = 1024    // Messages larger than this many bytes are compressed
+	MaxDecompressedSize  = 1 << 20 // Maximum size of a decompressed message, protects against decompression bombs
+)
+
+// compressedMarker is the first byte of compressed messages. Uncompressed
+// messages are JSON and never start with it
+const compressedMarker byte = 0x01
+
+// CompressMessage returns the data compressed with DEFLATE, or the data
+// itself if compressing does not make it smaller
+func CompressMessage(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(compressedMarker)
+	writer, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return data
+	}
+	if _, err := writer.Write(data); err != nil {
+		return data
+	}
+	if err := writer.Close(); err != nil {
+		return data
+	}
+	if buf.Len() >= len(data) {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// IsCompressedMessage returns true if the data was compressed with CompressMessage
+func IsCompressedMessage(data []byte) bool {
+	return len(data) > 0 && data[0] == compressedMarker
+}
+
+// DecompressMessage returns the decompressed data, or an error if it
+// expands to more than MaxDecompressedSize bytes
+func DecompressMessage(data []byte) ([]byte, error) {
+	if !IsCompressedMessage(data) {
+		return nil, fmt.Errorf("message is not compressed")
+	}
+	reader := flate.NewReader(bytes.NewReader(data[1:]))
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(reader, int64(MaxDecompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > MaxDecompressedSize {
+		return nil, fmt.Errorf("decompressed message exceeds %d bytes", MaxDecompressedSize)
+	}
+	return decompressed, nil
+}
@@ -1,22 +1,88 @@
 package kademlia_node
 
 import (
+	"bytes"
 	"fmt"
+	"net"
 	"sort"
+	"strconv"
+	"time"
 )
 
 // Contact definition
-// stores the KademliaID, the ip address and the distance
+// stores the KademliaID, the ip address, the distance, the public key
+// of nodes with encryption enabled and whether the node accepts compression.
+// The liveness of the contact is only kept locally by the routing table
 type Contact struct {
-	Id       *KademliaID `json:"Id"`
-	Ip       string      `json:"Ip"`
-	Port     int         `json:"Port"`
-	Distance *KademliaID `json:"Distance"`
+	Id          *KademliaID   `json:"Id"`
+	Ip          string        `json:"Ip"`
+	Port        int           `json:"Port"`
+	Distance    *KademliaID   `json:"Distance"`
+	PublicKey   []byte        `json:"PublicKey,omitempty"`
+	Compression bool          `json:"Compression,omitempty"`
+	Failures    int           `json:"-"` // Consecutive failed requests to the contact
+	FirstSeen   time.Time     `json:"-"` // When the contact was added to the routing table
+	LastSeen    time.Time     `json:"-"` // Last request from or response by the contact
+	LastRTT     time.Duration `json:"-"` // Round trip time of the last request to the contact
+	SmoothedRTT time.Duration `json:"-"` // Moving average of the round trip times
 }
 
 // NewContact returns a new instance of a Contact
 func NewContact(id *KademliaID, address string, port int) *Contact {
-	return &Contact{id, address, port, nil}
+	return &Contact{Id: id, Ip: address, Port: port}
+}
+
+// clone returns a copy of the contact that shares no IDs or keys with it
+func (contact Contact) clone() Contact {
+	if contact.Id != nil {
+		id := *contact.Id
+		contact.Id = &id
+	}
+	if contact.Distance != nil {
+		distance := *contact.Distance
+		contact.Distance = &distance
+	}
+	contact.PublicKey = bytes.Clone(contact.PublicKey)
+	return contact
+}
+
+// ValidateContact returns true if the contact can be used to reach a node
+func ValidateContact(contact *Contact) bool {
+	return contact != nil && contact.Id != nil && contact.Ip != "" && contact.Port > 0 && contact.Port <= 65535
+}
+
+// Address returns the host:port address of the contact,
+// IPv6 addresses are enclosed in brackets
+func (contact *Contact) Address() string {
+	return net.JoinHostPort(contact.Ip, strconv.Itoa(contact.Port))
+}
+
+// IsIPv6 returns true if the contact has an IPv6 address
+func (contact *Contact) IsIPv6() bool {
+	ip := net.ParseIP(contact.Ip)
+	return ip != nil && ip.To4() == nil
+}
+
+// Subnet returns the /24 network of an IPv4 contact or the /64 network of an
+// IPv6 contact, or an empty string if the contact has a host name
+func (contact *Contact) Subnet() string {
+	if net.ParseIP(contact.Ip) == nil {
+		return ""
+	}
+	return subnetOf(contact.Ip)
+}
+
+// subnetOf returns the /24 network of an IPv4 address or the /64 network of an
+// IPv6 address, host names are returned as they are
+func subnetOf(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return address
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
 }
 
 // CalcDistance calculates the distance to the target and
@@ -0,0 +1,69 @@
+package kademlia_node
+
+import (
+	"sync"
+)
+
+// Names of the counters kept by the node components
+const (
+	CounterHeaderMismatch      = "header_mismatch"
+	CounterUnauthenticated     = "unauthenticated"
+	CounterDecryptionFailed    = "decryption_failed"
+	CounterPlaintextRejected   = "plaintext_rejected"
+	CounterSpoofedResponse     = "spoofed_response"
+	CounterUnexpectedResponse  = "unexpected_response"
+	CounterRequestsQueued      = "requests_queued"
+	CounterRequestsProcessed   = "requests_processed"
+	CounterRequestsDropped     = "requests_dropped"
+	CounterResponsesDropped    = "responses_dropped"
+	CounterRateLimited         = "rate_limited"
+	CounterDuplicateRequests   = "duplicate_requests"
+	CounterFaultDropped        = "fault_dropped"
+	CounterFaultDelayed        = "fault_delayed"
+	CounterFaultDuplicated     = "fault_duplicated"
+	CounterFaultReordered      = "fault_reordered"
+	CounterDecompressionFailed = "decompression_failed"
+	CounterSameIPRejected      = "same_ip_rejected"
+	CounterSameSubnetRejected  = "same_subnet_rejected"
+)
+
+// Counters is a thread safe collection of named event counters
+type Counters struct {
+	counts map[string]uint64
+	Mutex  sync.RWMutex
+}
+
+// NewCounters returns a new instance of Counters
+func NewCounters() *Counters {
+	return &Counters{counts: make(map[string]uint64)}
+}
+
+// Increment increases the counter with the given name by one
+func (counters *Counters) Increment(name string) {
+	counters.Add(name, 1)
+}
+
+// Add increases the counter with the given name by delta
+func (counters *Counters) Add(name string, delta uint64) {
+	counters.Mutex.Lock()
+	defer counters.Mutex.Unlock()
+	counters.counts[name] += delta
+}
+
+// Get returns the current value of the counter with the given name
+func (counters *Counters) Get(name string) uint64 {
+	counters.Mutex.RLock()
+	defer counters.Mutex.RUnlock()
+	return counters.counts[name]
+}
+
+// Snapshot returns a copy of all counters
+func (counters *Counters) Snapshot() map[string]uint64 {
+	counters.Mutex.RLock()
+	defer counters.Mutex.RUnlock()
+	snapshot := make(map[string]uint64, len(counters.counts))
+	for name, count := range counters.counts {
+		snapshot[name] = count
+	}
+	return snapshot
+}
@@ -0,0 +1,92 @@
+package kademlia_node
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Encryption enables encrypted messaging for new nodes. Each node gets an
+// X25519 key pair and messages to contacts with a known public key are
+// encrypted with AES-GCM using a key derived from the pairwise shared secret
+var Encryption = false
+
+// SealedMessage is the wire format of an encrypted message
+type SealedMessage struct {
+	SenderKey  []byte `json:"SenderKey"`
+	Nonce      []byte `json:"Nonce"`
+	Ciphertext []byte `json:"Ciphertext"`
+}
+
+// NewKeyPair returns a new random X25519 key pair
+func NewKeyPair() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// SealMessage encrypts the plaintext for the owner of the recipient key
+func SealMessage(privateKey *ecdh.PrivateKey, recipientKey []byte, plaintext []byte) (*SealedMessage, error) {
+	senderKey := privateKey.PublicKey().Bytes()
+	aead, err := newAEAD(privateKey, recipientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &SealedMessage{
+		SenderKey:  senderKey,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, senderKey),
+	}, nil
+}
+
+// OpenMessage decrypts a message sealed for the owner of the private key
+func OpenMessage(privateKey *ecdh.PrivateKey, sealed *SealedMessage) ([]byte, error) {
+	aead, err := newAEAD(privateKey, sealed.SenderKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	return aead.Open(nil, sealed.Nonce, sealed.Ciphertext, sealed.SenderKey)
+}
+
+// newAEAD returns an AES-GCM cipher keyed with the shared secret of the
+// private key and the peer's public key
+func newAEAD(privateKey *ecdh.PrivateKey, peerKey []byte) (cipher.AEAD, error) {
+	publicKey, err := ecdh.X25519().NewPublicKey(peerKey)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := privateKey.ECDH(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive the key from the shared secret and both public keys in a fixed
+	// order so that both peers end up with the same key
+	ownKey := privateKey.PublicKey().Bytes()
+	mac := hmac.New(sha256.New, secret)
+	if bytes.Compare(ownKey, peerKey) < 0 {
+		mac.Write(ownKey)
+		mac.Write(peerKey)
+	} else {
+		mac.Write(peerKey)
+		mac.Write(ownKey)
+	}
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,93 @@
+package kademlia_node
+
+import (
+	"fmt"
+)
+
+// MaxDigitBits is the largest number of bits grouped into a digit
+const MaxDigitBits = 8
+
+// DigitBits is the number of bits b grouped into a digit by new routing tables.
+// With b > 1 the table keeps 2^b-1 buckets per digit, one for every value the
+// first digit differing from our own ID can take, so that every hop of a lookup
+// resolves a whole digit (section 4.2 of the Kademlia paper)
+var DigitBits = 1
+
+// SetDigitBits changes the number of bits grouped into a digit by new routing tables
+func SetDigitBits(b int) error {
+	if b < 1 || b > MaxDigitBits {
+		return fmt.Errorf("invalid digit bits %d: must be between 1 and %d", b, MaxDigitBits)
+	}
+	DigitBits = b
+	return nil
+}
+
+// digitCount returns the number of b-bit digits in a KademliaID,
+// the last digit may have fewer bits
+func digitCount(b int) int {
+	return (IDLength*8 + b - 1) / b
+}
+
+// digitWidth returns the number of bits of the digit at the given position,
+// which is less than b for the last digit if b does not divide the ID length
+func digitWidth(position int, b int) int {
+	if remaining := IDLength*8 - position*b; remaining < b {
+		return remaining
+	}
+	return b
+}
+
+// digit returns the value of the b-bit digit of the KademliaID at the given position
+func digit(id *KademliaID, position int, b int) int {
+	value := 0
+	for i := position * b; i < (position+1)*b && i < IDLength*8; i++ {
+		value = value<<1 | bit(id, i)
+	}
+	return value
+}
+
+// digitPrefix returns an ID starting with the first position digits of the
+// reference ID followed by the digit value, and the number of bits that are set
+func digitPrefix(referenceID *KademliaID, position int, value int, b int) (KademliaID, int) {
+	prefix := *referenceID
+	end := (position + 1) * b
+	if end > IDLength*8 {
+		end = IDLength * 8
+	}
+	for i := end - 1; i >= position*b; i-- {
+		mask := byte(0x80 >> (i % 8))
+		if value&1 == 1 {
+			prefix[i/8] |= mask
+		} else {
+			prefix[i/8] &^= mask
+		}
+		value >>= 1
+	}
+	return prefix, end
+}
+
+// newDigitBuckets returns the buckets of a b-bit digit routing table indexed by
+// digit position and value. The buckets for the digits of our own ID are nil
+func newDigitBuckets(me *KademliaID, b int, k int) [][]*bucket {
+	digits := make([][]*bucket, digitCount(b))
+	for position := range digits {
+		digits[position] = make([]*bucket, 1<<digitWidth(position, b))
+		for value := range digits[position] {
+			if value != digit(me, position, b) {
+				digits[position][value] = NewBucket(k)
+			}
+		}
+	}
+	return digits
+}
+
+// digitBucketFor returns the position and value of the first digit in which
+// the KademliaID differs from the reference ID, or -1 for the reference ID
+func digitBucketFor(id *KademliaID, referenceID *KademliaID, b int) (int, int) {
+	for position := 0; position < digitCount(b); position++ {
+		if value := digit(id, position, b); value != digit(referenceID, position, b) {
+			return position, value
+		}
+	}
+	return -1, -1
+}
@@ -0,0 +1,81 @@
+package kademlia_node
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// ExternalAddressQuorum is the number of networks peers have to observe the same
+	// address from before a node changes its advertised address
+	ExternalAddressQuorum = 3
+	ExternalReportTTL     = 30 * time.Minute // How long an observed address counts towards a quorum
+	MaxExternalReports    = 64               // Number of reports kept, the oldest is dropped first
+)
+
+// observation is an address a peer observed our requests coming from
+type observation struct {
+	ip         string
+	reportedAt time.Time
+}
+
+// ExternalAddress collects the addresses peers observed our requests coming from.
+// Votes are counted per network of the responding peers, since node IDs are not
+// authenticated and a single host could otherwise claim many of them
+type ExternalAddress struct {
+	Reports map[string]observation
+	Mutex   sync.Mutex
+}
+
+// NewExternalAddress returns a new instance of an ExternalAddress
+func NewExternalAddress() *ExternalAddress {
+	return &ExternalAddress{Reports: make(map[string]observation)}
+}
+
+// Report records the IP address observed by the peer responding from the responder
+// IP address and returns the address a quorum of networks agrees on, or an empty
+// string if there is none. Reports are cleared once a quorum is reached
+func (external *ExternalAddress) Report(responder string, ip string) string {
+	external.Mutex.Lock()
+	defer external.Mutex.Unlock()
+
+	now := time.Now()
+	external.expire(now)
+	network := subnetOf(responder)
+	if _, exists := external.Reports[network]; !exists && len(external.Reports) >= MaxExternalReports {
+		external.dropOldest()
+	}
+	external.Reports[network] = observation{ip: ip, reportedAt: now}
+
+	votes := 0
+	for _, reported := range external.Reports {
+		if reported.ip == ip {
+			votes++
+		}
+	}
+	if votes < ExternalAddressQuorum {
+		return ""
+	}
+	external.Reports = make(map[string]observation)
+	return ip
+}
+
+// expire removes the reports older than the ExternalReportTTL. The caller must hold the lock
+func (external *ExternalAddress) expire(now time.Time) {
+	for network, reported := range external.Reports {
+		if now.Sub(reported.reportedAt) > ExternalReportTTL {
+			delete(external.Reports, network)
+		}
+	}
+}
+
+// dropOldest removes the oldest report. The caller must hold the lock
+func (external *ExternalAddress) dropOldest() {
+	oldest := ""
+	for network, reported := range external.Reports {
+		if oldest == "" || reported.reportedAt.Before(external.Reports[oldest].reportedAt) {
+			oldest = network
+		}
+	}
+	delete(external.Reports, oldest)
+}
@@ -0,0 +1,202 @@
+package kademlia_node
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// LinkFaults describes the faults injected on a link between two nodes
+type LinkFaults struct {
+	Latency     time.Duration // Delay added to every message
+	Jitter      time.Duration // Maximum random delay added on top of the latency
+	Loss        float64       // Probability that a message is dropped
+	Duplication float64       // Probability that a message is delivered twice
+	Reorder     float64       // Probability that a message is held back so later messages overtake it
+}
+
+// FaultInjector holds the faults injected between nodes. The faults can be
+// changed at runtime and one injector can be shared by the FaultyNetworks
+// of all nodes of a test network
+type FaultInjector struct {
+	DefaultFaults LinkFaults
+	Links         map[[2]KademliaID]LinkFaults
+	Partitions    map[KademliaID]int
+	ReorderDelay  time.Duration // Extra delay of reordered messages
+	DropTimeout   time.Duration // Time a dropped request takes to fail, the timeout of the wrapped network if zero
+	Counters      *Counters
+	random        *rand.Rand
+	Mutex         sync.RWMutex
+}
+
+// FaultyNetwork wraps a NetworkInterface and injects latency, jitter, packet
+// loss, duplication, reordering and partitions between nodes. Requests and
+// their responses share a pending slot in the wrapped network, so requests are
+// only duplicated on a MemoryNetwork, which delivers the copy after the response
+type FaultyNetwork struct {
+	Network  NetworkInterface
+	Injector *FaultInjector
+}
+
+// NewFaultInjector returns a new instance of a FaultInjector without faults.
+// The seed makes the injected faults reproducible
+func NewFaultInjector(seed int64) *FaultInjector {
+	return &FaultInjector{
+		Links:        make(map[[2]KademliaID]LinkFaults),
+		Partitions:   make(map[KademliaID]int),
+		ReorderDelay: 50 * time.Millisecond,
+		Counters:     NewCounters(),
+		random:       rand.New(rand.NewSource(seed)),
+	}
+}
+
+// NewFaultyNetwork returns a new instance of a FaultyNetwork
+func NewFaultyNetwork(network NetworkInterface, injector *FaultInjector) *FaultyNetwork {
+	return &FaultyNetwork{Network: network, Injector: injector}
+}
+
+// SetDefaultFaults sets the faults of all links without faults of their own
+func (injector *FaultInjector) SetDefaultFaults(faults LinkFaults) {
+	injector.Mutex.Lock()
+	defer injector.Mutex.Unlock()
+	injector.DefaultFaults = faults
+}
+
+// SetLinkFaults sets the faults of the link between two nodes in both directions
+func (injector *FaultInjector) SetLinkFaults(a *KademliaID, b *KademliaID, faults LinkFaults) {
+	injector.Mutex.Lock()
+	defer injector.Mutex.Unlock()
+	injector.Links[[2]KademliaID{*a, *b}] = faults
+	injector.Links[[2]KademliaID{*b, *a}] = faults
+}
+
+// ClearLinkFaults removes the faults of the link between two nodes
+func (injector *FaultInjector) ClearLinkFaults(a *KademliaID, b *KademliaID) {
+	injector.Mutex.Lock()
+	defer injector.Mutex.Unlock()
+	delete(injector.Links, [2]KademliaID{*a, *b})
+	delete(injector.Links, [2]KademliaID{*b, *a})
+}
+
+// Partition splits the nodes into groups that can not reach each other.
+// Nodes not in any group can reach all nodes
+func (injector *FaultInjector) Partition(groups ...[]*KademliaID) {
+	injector.Mutex.Lock()
+	defer injector.Mutex.Unlock()
+	injector.Partitions = make(map[KademliaID]int)
+	for i, group := range groups {
+		for _, id := range group {
+			injector.Partitions[*id] = i
+		}
+	}
+}
+
+// Heal removes all partitions
+func (injector *FaultInjector) Heal() {
+	injector.Partition()
+}
+
+// SendRequest sends the request through the wrapped network after injecting faults
+func (faulty *FaultyNetwork) SendRequest(rpc *RPC) (*RPC, error) {
+	memory, canDuplicate := faulty.Network.(*MemoryNetwork)
+	drop, delay, duplicate := faulty.Injector.decide(rpc, canDuplicate)
+	if drop {
+		time.Sleep(faulty.dropTimeout())
+		return &RPC{}, fmt.Errorf("timeout waiting for response to RPC ID: %s", rpc.ID)
+	}
+	time.Sleep(delay)
+	response, err := faulty.Network.SendRequest(rpc)
+	if duplicate {
+		memory.Deliver(rpc)
+	}
+	return response, err
+}
+
+// SendResponse sends the response through the wrapped network after injecting faults
+func (faulty *FaultyNetwork) SendResponse(rpc *RPC) {
+	drop, delay, duplicate := faulty.Injector.decide(rpc, true)
+	if drop {
+		return
+	}
+	go func() {
+		time.Sleep(delay)
+		faulty.Network.SendResponse(rpc)
+		if duplicate {
+			faulty.Network.SendResponse(rpc)
+		}
+	}()
+}
+
+// Listen starts the wrapped network
+func (faulty *FaultyNetwork) Listen() {
+	faulty.Network.Listen()
+}
+
+// Write writes through the wrapped network
+func (faulty *FaultyNetwork) Write(listener *net.UDPConn, serializedMessage []byte, addrPort *net.UDPAddr) {
+	faulty.Network.Write(listener, serializedMessage, addrPort)
+}
+
+// dropTimeout returns the time a dropped request takes to fail
+func (faulty *FaultyNetwork) dropTimeout() time.Duration {
+	faulty.Injector.Mutex.RLock()
+	defer faulty.Injector.Mutex.RUnlock()
+	if faulty.Injector.DropTimeout > 0 {
+		return faulty.Injector.DropTimeout
+	}
+	if _, ok := faulty.Network.(*MemoryNetwork); ok {
+		return MemoryTimeout
+	}
+	return Timeout
+}
+
+// decide returns whether the RPC is dropped, how long it is delayed and
+// whether it is duplicated if duplication is possible
+func (injector *FaultInjector) decide(rpc *RPC, canDuplicate bool) (bool, time.Duration, bool) {
+	injector.Mutex.Lock()
+	defer injector.Mutex.Unlock()
+
+	faults := injector.DefaultFaults
+	if rpc.Source != nil && rpc.Source.Id != nil && rpc.Destination != nil && rpc.Destination.Id != nil {
+		if injector.partitioned(rpc.Source.Id, rpc.Destination.Id) {
+			injector.Counters.Increment(CounterFaultDropped)
+			return true, 0, false
+		}
+		if link, exists := injector.Links[[2]KademliaID{*rpc.Source.Id, *rpc.Destination.Id}]; exists {
+			faults = link
+		}
+	}
+
+	if injector.random.Float64() < faults.Loss {
+		injector.Counters.Increment(CounterFaultDropped)
+		return true, 0, false
+	}
+
+	delay := faults.Latency
+	if faults.Jitter > 0 {
+		delay += time.Duration(injector.random.Int63n(int64(faults.Jitter)))
+	}
+	if injector.random.Float64() < faults.Reorder {
+		delay += injector.ReorderDelay
+		injector.Counters.Increment(CounterFaultReordered)
+	}
+	if delay > 0 {
+		injector.Counters.Increment(CounterFaultDelayed)
+	}
+
+	duplicate := canDuplicate && injector.random.Float64() < faults.Duplication
+	if duplicate {
+		injector.Counters.Increment(CounterFaultDuplicated)
+	}
+	return false, delay, duplicate
+}
+
+// partitioned returns true if the nodes are in different partitions.
+// The caller must hold the lock
+func (injector *FaultInjector) partitioned(a *KademliaID, b *KademliaID) bool {
+	groupA, inA := injector.Partitions[*a]
+	groupB, inB := injector.Partitions[*b]
+	return inA && inB && groupA != groupB
+}
@@ -1,16 +1,33 @@
 package kademlia_node
 
 import (
+	crand "crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"math/rand"
 	"time"
 )
 
-// the static number of bytes in a KademliaID
-const IDLength = 20
+// the largest supported number of bytes in a KademliaID
+const MaxIDLength = 32
+
+// the number of bytes in a KademliaID, the same for all nodes of a network.
+// Change it with SetIDLength before creating any KademliaIDs
+var IDLength = 20
 
 // type definition of a KademliaID
-type KademliaID [IDLength]byte
+// only the first IDLength bytes are used, the others are always zero
+type KademliaID [MaxIDLength]byte
+
+// SetIDLength changes the number of bytes in a KademliaID
+func SetIDLength(length int) error {
+	if length < 1 || length > MaxIDLength {
+		return fmt.Errorf("invalid ID length %d: must be between 1 and %d bytes", length, MaxIDLength)
+	}
+	IDLength = length
+	return nil
+}
 
 // NewKademliaID returns a new instance of a KademliaID based on the string input
 func NewKademliaID(data string) *KademliaID {
@@ -38,8 +55,20 @@ func NewRandomKademliaID() *KademliaID {
 	return &newKademliaID
 }
 
+// NewSecureRandomKademliaID returns a new instance of a random KademliaID
+// generated from a cryptographically secure source, used for RPC IDs
+// so that responses can not be forged by guessing the ID
+func NewSecureRandomKademliaID() *KademliaID {
+	newKademliaID := KademliaID{}
+	if _, err := crand.Read(newKademliaID[:IDLength]); err != nil {
+		panic(err)
+	}
+	return &newKademliaID
+}
+
 // NewRandomKademliaIDInBucket returns a new instance of a random KademliaID
-// that is within the bounds of the bucket index
+// that is within the bounds of the bucket index, i.e. that shares the first
+// bucketIndex bits with the reference ID and differs in the next bit
 func NewRandomKademliaIDInBucket(bucketIndex int, referenceID *KademliaID) *KademliaID {
 	lowerBound := KademliaID{}
 	upperBound := KademliaID{}
@@ -74,6 +103,10 @@ func NewRandomKademliaIDInBucket(bucketIndex int, referenceID *KademliaID) *Kade
 			}
 		}
 	}
+	if bucketIndex < IDLength*8 {
+		mask := byte(0x80 >> (bucketIndex % 8))
+		newKademliaID[bucketIndex/8] = newKademliaID[bucketIndex/8]&^mask | ^referenceID[bucketIndex/8]&mask
+	}
 
 	return &newKademliaID
 }
@@ -98,7 +131,7 @@ func (kademliaID KademliaID) Equals(otherKademliaID *KademliaID) bool {
 	return true
 }
 
-// CalcDistance returns a new instance of a KademliaID that is built 
+// CalcDistance returns a new instance of a KademliaID that is built
 // through a bitwise XOR operation betweeen kademliaID and target
 func (kademliaID KademliaID) CalcDistance(target *KademliaID) *KademliaID {
 	result := KademliaID{}
@@ -112,3 +145,27 @@ func (kademliaID KademliaID) CalcDistance(target *KademliaID) *KademliaID {
 func (kademliaID *KademliaID) String() string {
 	return hex.EncodeToString(kademliaID[0:IDLength])
 }
+
+// MarshalJSON encodes the KademliaID as a hex string of IDLength bytes
+func (kademliaID KademliaID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(kademliaID.String())
+}
+
+// UnmarshalJSON decodes a hex string of exactly IDLength bytes, so that
+// KademliaIDs of nodes using another ID length are refused
+func (kademliaID *KademliaID) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := hex.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	if len(decoded) != IDLength {
+		return fmt.Errorf("invalid KademliaID length %d: want %d bytes", len(decoded), IDLength)
+	}
+	*kademliaID = KademliaID{}
+	copy(kademliaID[:], decoded)
+	return nil
+}
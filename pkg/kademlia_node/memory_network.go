@@ -0,0 +1,236 @@
+package kademlia_node
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// MemoryTimeout is the time a MemoryNetwork waits for a response
+var MemoryTimeout = 500 * time.Millisecond
+
+// MemoryHub connects the MemoryNetworks of nodes running in the same
+// process, so that networks of nodes can be tested without Docker
+type MemoryHub struct {
+	Networks map[string]*MemoryNetwork
+	NextPort int
+	Mutex    sync.RWMutex
+	// Requests being processed and delivered so far, guarded by Idle.L
+	processing int
+	delivered  int
+	Idle       *sync.Cond // Signalled when no requests are being processed
+}
+
+// NewMemoryHub returns a new instance of a MemoryHub
+func NewMemoryHub() *MemoryHub {
+	return &MemoryHub{Networks: make(map[string]*MemoryNetwork), NextPort: 10000, Idle: sync.NewCond(&sync.Mutex{})}
+}
+
+// Register connects the network to the hub under the address of its node
+func (hub *MemoryHub) Register(network *MemoryNetwork) {
+	hub.Mutex.Lock()
+	defer hub.Mutex.Unlock()
+	hub.Networks[network.Node.GetMe().Address()] = network
+}
+
+// Unregister disconnects the network from the hub, as if the node crashed
+func (hub *MemoryHub) Unregister(network *MemoryNetwork) {
+	hub.Mutex.Lock()
+	defer hub.Mutex.Unlock()
+	delete(hub.Networks, network.Node.GetMe().Address())
+}
+
+// Lookup returns the network registered under the address of the contact
+func (hub *MemoryHub) Lookup(contact *Contact) (*MemoryNetwork, bool) {
+	hub.Mutex.RLock()
+	defer hub.Mutex.RUnlock()
+	network, exists := hub.Networks[contact.Address()]
+	return network, exists
+}
+
+// Wait blocks until the requests delivered by the hub have been processed
+// and the evictions they caused have been applied
+func (hub *MemoryHub) Wait() {
+	for {
+		delivered := hub.waitIdle()
+		hub.Mutex.RLock()
+		networks := make([]*MemoryNetwork, 0, len(hub.Networks))
+		for _, network := range hub.Networks {
+			networks = append(networks, network)
+		}
+		hub.Mutex.RUnlock()
+		for _, network := range networks {
+			if routingTable, ok := network.Node.RoutingTable.(*RoutingTable); ok {
+				routingTable.WaitForEvictions()
+			}
+		}
+		// Evictions send new requests, which may start new evictions
+		if hub.waitIdle() == delivered {
+			return
+		}
+	}
+}
+
+// waitIdle blocks until no requests are being processed
+// and returns the number of requests delivered so far
+func (hub *MemoryHub) waitIdle() int {
+	hub.Idle.L.Lock()
+	defer hub.Idle.L.Unlock()
+	for hub.processing > 0 {
+		hub.Idle.Wait()
+	}
+	return hub.delivered
+}
+
+// process processes the request at the destination in the background
+func (hub *MemoryHub) process(destination *MemoryNetwork, request *RPC) {
+	hub.Idle.L.Lock()
+	hub.processing++
+	hub.delivered++
+	hub.Idle.L.Unlock()
+	go func() {
+		destination.Node.MessageHandler.ProcessRequest(request)
+		hub.Idle.L.Lock()
+		defer hub.Idle.L.Unlock()
+		hub.processing--
+		if hub.processing == 0 {
+			hub.Idle.Broadcast()
+		}
+	}()
+}
+
+// nextPort returns a port not used by any other node of the hub
+func (hub *MemoryHub) nextPort() int {
+	hub.Mutex.Lock()
+	defer hub.Mutex.Unlock()
+	hub.NextPort++
+	return hub.NextPort
+}
+
+// MemoryNetwork is a NetworkInterface that delivers messages to other nodes
+// of the same MemoryHub. Messages are serialized and deserialized as on the
+// wire, but never leave the process
+type MemoryNetwork struct {
+	Node         *Node
+	Hub          *MemoryHub
+	SentRequests map[string]*PendingRequest
+	MutexRequest sync.RWMutex
+}
+
+// NewMemoryNetwork returns a new instance of a MemoryNetwork registered at the hub
+func NewMemoryNetwork(hub *MemoryHub, node *Node) *MemoryNetwork {
+	network := &MemoryNetwork{
+		Node:         node,
+		Hub:          hub,
+		SentRequests: make(map[string]*PendingRequest),
+	}
+	hub.Register(network)
+	return network
+}
+
+// NewMemoryNode returns a node connected to the hub instead of a UDP network
+func NewMemoryNode(hub *MemoryHub, id *KademliaID, k int, alpha int) *Node {
+	node := &Node{
+		Me:    NewContact(id, "127.0.0.1", hub.nextPort()),
+		K:     k,
+		Alpha: alpha,
+	}
+	node.Me.Compression = Compression
+	node.RoutingTable = NewRoutingTable(node)
+	node.MessageHandler = NewMessageHandler(node)
+	node.Network = NewMemoryNetwork(hub, node)
+	return node
+}
+
+// SendRequest delivers the RPC to the destination node and waits for a response
+func (network *MemoryNetwork) SendRequest(rpc *RPC) (*RPC, error) {
+	destination, exists := network.Hub.Lookup(rpc.Destination)
+
+	recievedResponse := make(chan *RPC, 1)
+	reqID := rpc.ID.String()
+	network.MutexRequest.Lock()
+	network.SentRequests[reqID] = &PendingRequest{Destination: rpc.Destination, Response: recievedResponse}
+	network.MutexRequest.Unlock()
+
+	defer func() {
+		network.MutexRequest.Lock()
+		delete(network.SentRequests, reqID)
+		network.MutexRequest.Unlock()
+	}()
+
+	if exists {
+		request, err := network.transfer(destination, rpc)
+		if err != nil {
+			return &RPC{}, err
+		}
+		network.Hub.process(destination, request)
+	}
+
+	select {
+	case response := <-recievedResponse:
+		return response, nil
+	case <-time.After(MemoryTimeout):
+		return &RPC{}, fmt.Errorf("timeout waiting for response to RPC ID: %s", rpc.ID)
+	}
+}
+
+// SendResponse delivers the RPC to the node waiting for it
+func (network *MemoryNetwork) SendResponse(rpc *RPC) {
+	destination, exists := network.Hub.Lookup(rpc.Destination)
+	if !exists {
+		return
+	}
+	response, err := network.transfer(destination, rpc)
+	if err != nil {
+		return
+	}
+
+	destination.MutexRequest.RLock()
+	pending, exists := destination.SentRequests[response.ID.String()]
+	destination.MutexRequest.RUnlock()
+	if !exists || ValidateResponse(pending, response, nil) != nil {
+		return
+	}
+	// Drop the response if one has already been delivered
+	select {
+	case pending.Response <- response:
+	default:
+	}
+}
+
+// Deliver delivers a copy of the request to the destination node without
+// waiting for a response, as a duplicated datagram would arrive
+func (network *MemoryNetwork) Deliver(rpc *RPC) {
+	destination, exists := network.Hub.Lookup(rpc.Destination)
+	if !exists {
+		return
+	}
+	request, err := network.transfer(destination, rpc)
+	if err != nil {
+		return
+	}
+	network.Hub.process(destination, request)
+}
+
+// Listen does nothing since messages are delivered directly
+func (network *MemoryNetwork) Listen() {
+}
+
+// Write does nothing since messages are delivered directly
+func (network *MemoryNetwork) Write(*net.UDPConn, []byte, *net.UDPAddr) {
+}
+
+// transfer serializes the RPC as the sender and deserializes it as the destination
+func (network *MemoryNetwork) transfer(destination *MemoryNetwork, rpc *RPC) (*RPC, error) {
+	data, err := network.Node.MessageHandler.SerializeMessage(rpc)
+	if err != nil {
+		return nil, err
+	}
+	received, err := destination.Node.MessageHandler.DeserializeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	received.From = &net.UDPAddr{IP: net.ParseIP(network.Node.GetMe().Ip), Port: network.Node.GetMe().Port}
+	return received, nil
+}
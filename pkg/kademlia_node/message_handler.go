@@ -1,8 +1,10 @@
 package kademlia_node
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 type MessageHandlerInterface interface {
@@ -20,11 +22,13 @@ type MessageHandlerInterface interface {
 }
 
 type MessageHandler struct {
-	Node *Node
+	Node          *Node
+	Counters      *Counters
+	ResponseCache *ResponseCache
 }
 
 func NewMessageHandler(node *Node) *MessageHandler {
-	handler := &MessageHandler{Node: node}
+	handler := &MessageHandler{Node: node, Counters: NewCounters(), ResponseCache: NewResponseCache()}
 	return handler
 }
 
@@ -33,29 +37,56 @@ func (handler *MessageHandler) ProcessRequest(rpc *RPC) (*RPC, error) {
 		return nil, fmt.Errorf("invalid RPC")
 	}
 
+	// Senders from other protocol versions or networks are never added to the routing table
+	if err := ValidateHeader(rpc); err != nil {
+		handler.Counters.Increment(CounterHeaderMismatch)
+		fmt.Println("Rejected request:", err)
+		return nil, err
+	}
+
+	// Answer duplicate and retried requests with the response to the first one
+	if rpc.Source != nil && rpc.Source.Id != nil && rpc.ID != nil {
+		cached, sent, duplicate := handler.ResponseCache.Begin(rpc)
+		if duplicate {
+			handler.Counters.Increment(CounterDuplicateRequests)
+			if cached == nil {
+				return nil, fmt.Errorf("duplicate of request %s in progress", rpc.ID)
+			}
+			// Reply the same way as to the first request, which may have been lost
+			if sent {
+				handler.Node.Network.SendResponse(cached)
+			}
+			return cached, nil
+		}
+	}
+
 	fmt.Println("RPC: ", rpc)
 	// Add the source to the routing table or update it
 	handler.Node.RoutingTable.AddContact(rpc.Source)
 	fmt.Println("Added contact to routing table")
 
+	// Remember which responses were sent so that duplicates are answered the same way
+	var response *RPC
+	sent := false
 	switch rpc.Type {
 	case PingRequest:
-		rpc := handler.SendPingResponse(rpc)
-		return rpc, nil
+		response = handler.SendPingResponse(rpc)
+		sent = true
 	case StoreRequest:
 		// TODO: Store the data
-		rpc := handler.SendStoreResponse(rpc)
-		return rpc, nil
+		response = handler.SendStoreResponse(rpc)
 	case FindNodeRequest:
-		rpc := handler.SendFindNodeResponse(rpc)
-		return rpc, nil
+		response = handler.SendFindNodeResponse(rpc)
+		sent = true
 	case FindValueRequest:
 		// TODO: Find the value
-		rpc := handler.SendFindValueResponse(rpc)
-		return rpc, nil
+		response = handler.SendFindValueResponse(rpc)
 	default:
+		handler.ResponseCache.Abort(rpc)
 		return nil, fmt.Errorf("invalid RPC")
 	}
+	handler.ResponseCache.Complete(rpc, response, sent)
+	return response, nil
 }
 
 func (handler *MessageHandler) SerializeMessage(rpc *RPC) (data []byte, err error) {
@@ -63,36 +94,132 @@ func (handler *MessageHandler) SerializeMessage(rpc *RPC) (data []byte, err erro
 	if err != nil {
 		return nil, err
 	}
-	return data, nil
+
+	// Encrypt the message if both we and the destination have a key pair. With a key
+	// pair only pings, which exchange the keys, may be sent in plaintext
+	seal := handler.Node.PrivateKey != nil && rpc.Destination != nil && len(rpc.Destination.PublicKey) > 0
+	if handler.Node.PrivateKey != nil && !seal && rpc.Type != PingRequest {
+		return nil, fmt.Errorf("refusing to send %s in plaintext to a contact without a public key", rpc.Type)
+	}
+
+	// Compress large messages if both we and the destination support it. Encrypted
+	// messages are never compressed, since the compressed length leaks the content
+	if !seal && Compression && rpc.Destination != nil && rpc.Destination.Compression && len(data) > CompressionThreshold {
+		data = CompressMessage(data)
+	}
+
+	if seal {
+		sealed, err := SealMessage(handler.Node.PrivateKey, rpc.Destination.PublicKey, data)
+		if err != nil {
+			return nil, err
+		}
+		data, err = json.Marshal(sealed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return SignMessage(data), nil
 }
 
 func (handler *MessageHandler) DeserializeMessage(data []byte) (*RPC, error) {
+	// Discard unauthenticated or tampered messages before parsing them
+	data, err := VerifyMessage(data)
+	if err != nil {
+		handler.Counters.Increment(CounterUnauthenticated)
+		return nil, err
+	}
+
+	// Decrypt the message if it was sealed for us
+	var sealed SealedMessage
+	if json.Unmarshal(data, &sealed) == nil && len(sealed.Ciphertext) > 0 {
+		if handler.Node.PrivateKey == nil {
+			handler.Counters.Increment(CounterDecryptionFailed)
+			return nil, fmt.Errorf("received encrypted message without a key pair")
+		}
+		data, err = OpenMessage(handler.Node.PrivateKey, &sealed)
+		if err != nil {
+			handler.Counters.Increment(CounterDecryptionFailed)
+			return nil, err
+		}
+	}
+
+	// Decompress the message with a limit on its expanded size, encrypted messages are never compressed
+	if len(sealed.Ciphertext) == 0 && IsCompressedMessage(data) {
+		data, err = DecompressMessage(data)
+		if err != nil {
+			handler.Counters.Increment(CounterDecompressionFailed)
+			return nil, err
+		}
+	}
+
 	var rpc RPC
-	err := json.Unmarshal(data, &rpc)
+	err = json.Unmarshal(data, &rpc)
 	if err != nil {
+		// The header comes first, so it is decoded before the IDs of nodes using
+		// another ID length or protocol version fail to decode
+		if rpc.Header != nil {
+			if headerErr := ValidateHeader(&rpc); headerErr != nil {
+				handler.Counters.Increment(CounterHeaderMismatch)
+				return nil, headerErr
+			}
+		}
 		return nil, err
 	}
+
+	// The sender of an encrypted message must be the source of the RPC
+	if len(sealed.Ciphertext) > 0 && (rpc.Source == nil || !bytes.Equal(rpc.Source.PublicKey, sealed.SenderKey)) {
+		handler.Counters.Increment(CounterDecryptionFailed)
+		return nil, fmt.Errorf("sender key does not match the source of the message")
+	}
+	// With a key pair only pings, which exchange the keys, are accepted in plaintext,
+	// so that peers can not downgrade the connection
+	if handler.Node.PrivateKey != nil && len(sealed.Ciphertext) == 0 && rpc.Type != PingRequest {
+		handler.Counters.Increment(CounterPlaintextRejected)
+		return nil, fmt.Errorf("received %s in plaintext", rpc.Type)
+	}
 	return &rpc, nil
 }
 
+// sendRequest sends the request and records its outcome and round trip time for the
+// destination in the routing table, so that contacts that keep failing are evicted
+func (handler *MessageHandler) sendRequest(rpc *RPC) (*RPC, error) {
+	sentAt := time.Now()
+	response, err := handler.Node.Network.SendRequest(rpc)
+	if handler.Node.RoutingTable != nil && rpc.Destination != nil && rpc.Destination.Id != nil {
+		if err != nil {
+			handler.Node.RoutingTable.RecordFailure(rpc.Destination)
+		} else {
+			handler.Node.RoutingTable.RecordSuccess(rpc.Destination, time.Since(sentAt))
+		}
+	}
+	return response, err
+}
+
 func (handler *MessageHandler) SendPingRequest(source *Contact, destination *Contact) (*RPC, error) {
 	//TODO: implement
-	rpc := NewRPC(PingRequest, false, NewRandomKademliaID(), nil, source, destination)
-	response, err := handler.Node.Network.SendRequest(rpc)
+	rpc := NewRPC(PingRequest, false, NewSecureRandomKademliaID(), nil, source, destination)
+	response, err := handler.sendRequest(rpc)
+	if err == nil && response.Payload != nil && response.Payload.ObservedIp != "" && response.From != nil {
+		handler.Node.ReportObservedAddress(response.From.IP.String(), response.Payload.ObservedIp)
+	}
 	return response, err
 }
 
 func (handler *MessageHandler) SendPingResponse(requestRPC *RPC) *RPC {
-	//TODO: implement
-	rpc := NewRPC(PingResponse, true, requestRPC.ID, nil, requestRPC.Destination, requestRPC.Source)
+	// Tell the requester which address we saw the request come from
+	var payload *Payload
+	if requestRPC.From != nil {
+		payload = &Payload{ObservedIp: requestRPC.From.IP.String()}
+	}
+	rpc := NewRPC(PingResponse, true, requestRPC.ID, payload, requestRPC.Destination, requestRPC.Source)
 	handler.Node.Network.SendResponse(rpc)
 	return rpc
 }
 
 func (handler *MessageHandler) SendStoreRequest(source *Contact, destination *Contact, data []byte) (*RPC, error) {
 	//TODO: implement
-	rpc := NewRPC(StoreRequest, false, NewRandomKademliaID(), NewPayload(NewRandomKademliaID(), data, nil), source, destination)
-	response, err := handler.Node.Network.SendRequest(rpc)
+	rpc := NewRPC(StoreRequest, false, NewSecureRandomKademliaID(), NewPayload(NewRandomKademliaID(), data, nil), source, destination)
+	response, err := handler.sendRequest(rpc)
 	return response, err
 }
 
@@ -103,8 +230,8 @@ func (handler *MessageHandler) SendStoreResponse(requestRPC *RPC) *RPC {
 }
 
 func (handler *MessageHandler) SendFindNodeRequest(source *Contact, destination *Contact, target *KademliaID) (*RPC, error) {
-	rpc := NewRPC(FindNodeRequest, false, NewRandomKademliaID(), NewPayload(target, nil, nil), source, destination)
-	response, err := handler.Node.Network.SendRequest(rpc)
+	rpc := NewRPC(FindNodeRequest, false, NewSecureRandomKademliaID(), NewPayload(target, nil, nil), source, destination)
+	response, err := handler.sendRequest(rpc)
 	return response, err
 }
 
@@ -118,8 +245,8 @@ func (handler *MessageHandler) SendFindNodeResponse(requestRPC *RPC) *RPC {
 
 func (handler *MessageHandler) SendFindValueRequest(source *Contact, destination *Contact, key *KademliaID) (*RPC, error) {
 	//TODO: implement
-	rpc := NewRPC(FindValueRequest, false, NewRandomKademliaID(), nil, source, destination)
-	response, err := handler.Node.Network.SendRequest(rpc)
+	rpc := NewRPC(FindValueRequest, false, NewSecureRandomKademliaID(), nil, source, destination)
+	response, err := handler.sendRequest(rpc)
 	return response, err
 }
 
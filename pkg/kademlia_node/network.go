@@ -11,9 +11,24 @@ import (
 )
 
 var (
-	Timeout         = 3 * time.Second // Timeout for waiting for a response
-	Buffer          = 50              // Buffer size for the response queue
-	NumberOfWorkers = 10              // Number of response workers
+	Timeout                = 3 * time.Second // Timeout for waiting for a response from contacts without RTT samples
+	Buffer                 = 50              // Buffer size for the response queue
+	NumberOfWorkers        = 10              // Number of response workers
+	RequestBuffer          = 100             // Buffer size for the inbound request queue
+	NumberOfRequestWorkers = 10              // Number of inbound request workers
+	RequestDropPolicy      = DropNewest      // What to drop when the inbound request queue is full
+	InterfaceName          = "eth0"          // Network interface the node address is taken from
+	PreferIPv6             = false           // Advertise an IPv6 address of the interface if there is one
+	DualStack              = false           // Listen on all IPv4 and IPv6 addresses
+	FilterUnreachable      = false           // Skip contacts of the other IP family, also dual stack peers advertising it, see CanReach
+)
+
+// DropPolicy decides which request is dropped when the request queue is full
+type DropPolicy int
+
+const (
+	DropNewest DropPolicy = iota // Drop the request that did not fit in the queue
+	DropOldest                   // Drop the oldest queued request to make room
 )
 
 // NetworkInterface is an interface for sending and receiving messages
@@ -28,9 +43,20 @@ type Network struct {
 	Node          *Node
 	Wg            sync.WaitGroup
 	ResponseQueue chan *RPC
-	SentRequests  map[string]chan *RPC
+	RequestQueue  chan *RPC
+	SentRequests  map[string]*PendingRequest
 	MutexRequest  sync.RWMutex
 	MutexWrite    sync.RWMutex
+	Counters      *Counters
+	RTT           *RTTEstimator
+	RateLimiter   *RateLimiter
+}
+
+// PendingRequest is a request waiting for a response
+type PendingRequest struct {
+	Destination *Contact
+	Addr        *net.UDPAddr
+	Response    chan *RPC
 }
 
 var (
@@ -42,8 +68,12 @@ func NewNetwork(node *Node) *Network {
 	networkSingleton.Do(func() {
 		networkInstance = &Network{
 			ResponseQueue: make(chan *RPC, Buffer),
-			SentRequests:  make(map[string]chan *RPC),
+			RequestQueue:  make(chan *RPC, RequestBuffer),
+			SentRequests:  make(map[string]*PendingRequest),
 			Wg:            sync.WaitGroup{},
+			Counters:      NewCounters(),
+			RTT:           NewRTTEstimator(),
+			RateLimiter:   NewRateLimiter(RateLimits),
 			Node:          node}
 	})
 	return networkInstance
@@ -51,12 +81,16 @@ func NewNetwork(node *Node) *Network {
 
 // Listen starts a UDP listener on the specified IP and port of the network node.
 func (network *Network) Listen() {
-	fmt.Println(network.Node.Me.Ip)
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", network.Node.Me.Ip, network.Node.Me.Port))
+	fmt.Println(network.Node.GetMe().Ip)
+	addr, err := net.ResolveUDPAddr("udp", network.Node.GetMe().Address())
 	if err != nil {
 		fmt.Println("Error resolving UDP address:", err)
 		return
 	}
+	if DualStack {
+		// An unspecified IP listens on both IPv4 and IPv6
+		addr = &net.UDPAddr{Port: addr.Port}
+	}
 
 	listener, err := net.ListenUDP("udp", addr)
 	if err != nil {
@@ -65,7 +99,7 @@ func (network *Network) Listen() {
 	}
 	defer listener.Close()
 
-	fmt.Printf("Listening on %s:%d\n", network.Node.Me.Ip, network.Node.Me.Port)
+	fmt.Printf("Listening on %s\n", listener.LocalAddr())
 
 	// Start the goroutines for handling incoming and outgoing connections
 	network.Wg.Add(1)
@@ -76,6 +110,12 @@ func (network *Network) Listen() {
 		network.Wg.Add(1)
 		go network.ResponseWorker(listener)
 	}
+
+	// Create a bounded pool of request goroutines
+	for i := 0; i < NumberOfRequestWorkers; i++ {
+		network.Wg.Add(1)
+		go network.RequestWorker()
+	}
 	// WaitGroup to keep the goroutines alive
 	network.Wg.Wait()
 }
@@ -88,7 +128,7 @@ func (network *Network) read(listener *net.UDPConn) {
 	for {
 		fmt.Println("Waiting for message")
 
-		n, _, err := listener.ReadFromUDP(buf)
+		n, addr, err := listener.ReadFromUDP(buf)
 		if err != nil {
 			fmt.Println("Error reading from UDP connection:", err)
 			continue
@@ -100,20 +140,90 @@ func (network *Network) read(listener *net.UDPConn) {
 			continue
 		}
 		fmt.Println("Received message:", rpc)
+		rpc.From = addr
+
+		// Drop messages from other protocol versions or networks
+		if err := ValidateHeader(rpc); err != nil {
+			network.Counters.Increment(CounterHeaderMismatch)
+			fmt.Println("Rejected message:", err)
+			continue
+		}
 
 		// Check if the message is a response to a request
 		reqID := rpc.ID.String()
 		network.MutexRequest.RLock()
-		recievedResponse, exists := network.SentRequests[reqID]
+		pending, exists := network.SentRequests[reqID]
 		network.MutexRequest.RUnlock()
 
-		if !exists {
-			// Create a goroutine to handle the incoming requests
-			go network.Node.MessageHandler.ProcessRequest(rpc)
+		if !exists && rpc.IsResponse {
+			// Drop responses to unknown or expired requests before they use any budget or worker
+			network.Counters.Increment(CounterUnexpectedResponse)
+			fmt.Println("Dropped unexpected response with RPC ID: ", rpc.ID)
+		} else if !exists {
+			// Drop requests from sources exceeding their budget. The claimed node ID
+			// is only trusted when the message is authenticated with the cluster key,
+			// otherwise anyone could get another node banned
+			var sourceID *KademliaID
+			if len(ClusterKey) > 0 && rpc.Source != nil {
+				sourceID = rpc.Source.Id
+			}
+			if !network.RateLimiter.Allow(addr.IP.String(), sourceID, rpc.Type) {
+				network.Counters.Increment(CounterRateLimited)
+				fmt.Println("Rate limited request from", addr)
+				continue
+			}
+			// Queue the incoming request for the request workers
+			network.EnqueueRequest(rpc)
+		} else if err := ValidateResponse(pending, rpc, addr); err != nil {
+			network.Counters.Increment(CounterSpoofedResponse)
+			fmt.Println("Dropped response:", err)
 		} else {
-			recievedResponse <- rpc
+			// Drop the response if one has already been delivered
+			select {
+			case pending.Response <- rpc:
+			default:
+			}
+		}
+	}
+}
+
+// EnqueueRequest adds an incoming request to the request queue. When the
+// queue is full a request is dropped according to the RequestDropPolicy
+func (network *Network) EnqueueRequest(rpc *RPC) {
+	select {
+	case network.RequestQueue <- rpc:
+		network.Counters.Increment(CounterRequestsQueued)
+		return
+	default:
+	}
+
+	if RequestDropPolicy == DropOldest {
+		// Make room by dropping the oldest request
+		select {
+		case dropped := <-network.RequestQueue:
+			network.Counters.Increment(CounterRequestsDropped)
+			fmt.Println("Request queue full, dropped request with RPC ID: ", dropped.ID)
+		default:
+		}
+		select {
+		case network.RequestQueue <- rpc:
+			network.Counters.Increment(CounterRequestsQueued)
+			return
+		default:
 		}
 	}
+
+	network.Counters.Increment(CounterRequestsDropped)
+	fmt.Println("Request queue full, dropped request with RPC ID: ", rpc.ID)
+}
+
+// RequestWorker processes incoming requests from the request queue
+func (network *Network) RequestWorker() {
+	defer network.Wg.Done()
+	for rpc := range network.RequestQueue {
+		network.Node.MessageHandler.ProcessRequest(rpc)
+		network.Counters.Increment(CounterRequestsProcessed)
+	}
 }
 
 // ResponseWorker sends responses to the destination nodes
@@ -127,7 +237,7 @@ func (network *Network) ResponseWorker(listener *net.UDPConn) {
 			continue
 		}
 		// Get IP and port of the destination
-		addrPort, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", rpc.Destination.Ip, rpc.Destination.Port))
+		addrPort, err := net.ResolveUDPAddr("udp", rpc.Destination.Address())
 		if err != nil {
 			fmt.Println("Error parsing address and port:", err)
 			continue
@@ -150,15 +260,23 @@ func (network *Network) Write(listener *net.UDPConn, serializedMessage []byte, a
 	}
 }
 
+// SendResponse queues the response for the response workers. The response is
+// dropped when the queue is full, so that request workers never stall on it
 func (network *Network) SendResponse(rpc *RPC) {
 	fmt.Println("Adding response to channel with RPC ID: ", rpc.ID)
-	network.ResponseQueue <- rpc
+	select {
+	case network.ResponseQueue <- rpc:
+	default:
+		network.Counters.Increment(CounterResponsesDropped)
+		fmt.Println("Response queue full, dropped response with RPC ID: ", rpc.ID)
+	}
 }
 
-// SendRequest sends an RPC to the destination node and waits for a response
-// for a certain amount of time before timing out.
+// SendRequest sends an RPC to the destination node and waits for a response.
+// The timeout is derived from the round trip times of the destination and the
+// request is resent with exponential backoff until the retries are exhausted.
 func (network *Network) SendRequest(rpc *RPC) (*RPC, error) {
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", rpc.Destination.Ip, rpc.Destination.Port))
+	addr, err := net.ResolveUDPAddr("udp", rpc.Destination.Address())
 	if err != nil {
 		fmt.Println("Error resolving UDP address:", err)
 		return &RPC{}, err
@@ -179,11 +297,12 @@ func (network *Network) SendRequest(rpc *RPC) (*RPC, error) {
 		return &RPC{}, err
 	}
 
-	// Create a unique request ID and response channel
-	recievedResponse := make(chan *RPC)
+	// Create a unique request ID and response channel. The channel is buffered
+	// so that late or duplicate responses never block the reader
+	recievedResponse := make(chan *RPC, 1)
 	reqID := rpc.ID.String()
 	network.MutexRequest.Lock()
-	network.SentRequests[reqID] = recievedResponse
+	network.SentRequests[reqID] = &PendingRequest{Destination: rpc.Destination, Addr: addr, Response: recievedResponse}
 	network.MutexRequest.Unlock()
 
 	// Defer cleanup
@@ -191,28 +310,55 @@ func (network *Network) SendRequest(rpc *RPC) (*RPC, error) {
 		network.MutexRequest.Lock()
 		delete(network.SentRequests, reqID)
 		network.MutexRequest.Unlock()
-		close(recievedResponse)
 	}()
 
-	// Send the message
-	_, err = conn.Write(serializedMessage)
-	if err != nil {
-		fmt.Println("Error writing to UDP connection:", err)
-		return &RPC{}, err
+	timeout := Timeout
+	if rpc.Destination.Id != nil {
+		timeout = network.RTT.Timeout(rpc.Destination.Id)
 	}
 
-	fmt.Println("Sent Request: ", rpc)
+	for attempt := 0; attempt <= Retries; attempt++ {
+		// Send the message
+		sentAt := time.Now()
+		_, err = conn.Write(serializedMessage)
+		if err != nil {
+			fmt.Println("Error writing to UDP connection:", err)
+			return &RPC{}, err
+		}
+
+		fmt.Println("Sent Request: ", rpc)
 
-	fmt.Println("Waiting for response to RPC ID: ", rpc.ID)
-	// Wait for response or timeout
-	select {
-	case response := <-recievedResponse:
-		fmt.Println("Received Response: ", response)
-		return response, nil
-	case <-time.After(Timeout):
-		fmt.Println("Timeout waiting for response to RPC ID: ", rpc.ID)
-		return &RPC{}, fmt.Errorf("timeout waiting for response to RPC ID: %s", rpc.ID)
+		fmt.Println("Waiting for response to RPC ID: ", rpc.ID)
+		// Wait for response or timeout
+		select {
+		case response := <-recievedResponse:
+			fmt.Println("Received Response: ", response)
+			// Only sample the first attempt since responses to retries are ambiguous
+			if attempt == 0 && rpc.Destination.Id != nil {
+				network.RTT.Update(rpc.Destination.Id, time.Since(sentAt))
+			}
+			return response, nil
+		case <-time.After(RetryTimeout(timeout, attempt)):
+			fmt.Println("Timeout waiting for response to RPC ID: ", rpc.ID)
+			if rpc.Destination.Id != nil {
+				network.RTT.RecordTimeout(rpc.Destination.Id)
+			}
+		}
+	}
+	return &RPC{}, fmt.Errorf("timeout waiting for response to RPC ID: %s", rpc.ID)
+}
+
+// ValidateResponse returns an error if the response was not sent by the
+// destination of the pending request. Only the IP address of the sender is
+// compared since responses may leave the destination through another socket.
+func ValidateResponse(pending *PendingRequest, response *RPC, addr *net.UDPAddr) error {
+	if pending.Destination.Id != nil && (response.Source == nil || response.Source.Id == nil || !response.Source.Id.Equals(pending.Destination.Id)) {
+		return fmt.Errorf("response to RPC ID %s does not come from contact %s", response.ID, pending.Destination.Id)
+	}
+	if pending.Addr != nil && addr != nil && !pending.Addr.IP.Equal(addr.IP) {
+		return fmt.Errorf("response to RPC ID %s sent from %s instead of %s", response.ID, addr.IP, pending.Addr.IP)
 	}
+	return nil
 }
 
 // Get random port between 1024 and 65535
@@ -234,7 +380,8 @@ func GetRandomPortOrDefault() int {
 }
 
 // GetLocalIp returns the local ip address of the interface
-// with the given name
+// with the given name. IPv4 addresses are preferred unless PreferIPv6 is set,
+// link-local IPv6 addresses are never returned since they need a zone
 func GetLocalIp(interfaceName string) string {
 	iface, err := net.InterfaceByName(interfaceName)
 	if err != nil {
@@ -248,12 +395,24 @@ func GetLocalIp(interfaceName string) string {
 		return "127.0.0.1"
 	}
 
+	var ipv4, ipv6 string
 	for _, addr := range addrs {
 		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
 			if ipNet.IP.To4() != nil {
-				return ipNet.IP.String()
+				if ipv4 == "" {
+					ipv4 = ipNet.IP.String()
+				}
+			} else if ipNet.IP.IsGlobalUnicast() && ipv6 == "" {
+				ipv6 = ipNet.IP.String()
 			}
 		}
 	}
+
+	if ipv6 != "" && (PreferIPv6 || ipv4 == "") {
+		return ipv6
+	}
+	if ipv4 != "" {
+		return ipv4
+	}
 	return "127.0.0.1"
 }
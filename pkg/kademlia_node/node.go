@@ -1,33 +1,59 @@
 package kademlia_node
 
 import (
+	"crypto/ecdh"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
+)
+
+var (
+	RefreshInterval      = time.Hour        // Buckets without activity for this long are refreshed
+	RefreshCheckInterval = time.Minute      // How often buckets are checked for staleness
+	StatsInterval        = time.Duration(0) // How often the routing table stats are logged, never if zero
 )
 
 type Node struct {
-	Me             *Contact
-	RoutingTable   *RoutingTable
-	Network        NetworkInterface
-	MessageHandler MessageHandlerInterface
-	K              int
-	Alpha          int
+	Me              *Contact // Read with GetMe once the node is running, the advertised address may change
+	MutexMe         sync.RWMutex
+	RoutingTable    RoutingTableInterface
+	Network         NetworkInterface
+	MessageHandler  MessageHandlerInterface
+	K               int
+	Alpha           int
+	PrivateKey      *ecdh.PrivateKey
+	ExternalAddress *ExternalAddress
 }
 
 // NewNode returns a new instance of a Node
 func NewNode(id *KademliaID) *Node {
 	k, _ := strconv.Atoi(os.Getenv("K"))
 	alpha, _ := strconv.Atoi(os.Getenv("ALPHA"))
-	ip := GetLocalIp("eth0")
+	ip := GetLocalIp(InterfaceName)
 	port := GetRandomPortOrDefault()
 	me := NewContact(id, ip, port)
+	me.Compression = Compression
 
 	node := &Node{
-		Me:    me,
-		K:     k,
-		Alpha: alpha,
+		Me:              me,
+		K:               k,
+		Alpha:           alpha,
+		ExternalAddress: NewExternalAddress(),
+	}
+
+	// Advertise the public key so that peers can encrypt messages to us
+	if Encryption {
+		privateKey, err := NewKeyPair()
+		if err != nil {
+			fmt.Println("Error generating key pair:", err)
+		} else {
+			node.PrivateKey = privateKey
+			me.PublicKey = privateKey.PublicKey().Bytes()
+		}
 	}
 
 	node.RoutingTable = NewRoutingTable(node)
@@ -37,12 +63,30 @@ func NewNode(id *KademliaID) *Node {
 	return node
 }
 
+// LookupStats describes the work done by a lookup
+type LookupStats struct {
+	Rounds  int // Rounds of up to Alpha concurrent FindNode requests
+	Queries int // FindNode requests sent
+	Hops    int // Rounds until the closest contact found was learned, 0 if it was in our RoutingTable
+}
+
 func (node *Node) LookupContact(target *Contact) []*Contact {
+	contacts, _ := node.LookupContactWithStats(target)
+	return contacts
+}
+
+// LookupContactWithStats finds the k closest contacts to the target like
+// LookupContact and also returns how many rounds and requests it took
+func (node *Node) LookupContactWithStats(target *Contact) ([]*Contact, LookupStats) {
+	var stats LookupStats
 	// Uses strict parallelism to find the k closest contacts to the destination
 	// i.e. Alpha concurrent FindNode requests
 	shortlist := NewShortlist(target.Id, node.K)
 	contacted := make(map[*KademliaID]bool)
 
+	// A lookup counts as activity in the bucket of the target
+	node.RoutingTable.Touch(target.Id)
+
 	// Get the initial k closest contacts to the destination
 	initialContacts := node.RoutingTable.FindClosestContacts(target.Id)
 	for _, contact := range initialContacts {
@@ -51,16 +95,31 @@ func (node *Node) LookupContact(target *Contact) []*Contact {
 
 	closestContact := shortlist.GetClosestContact()
 
+	// Round trip times to break ties between equally close contacts
+	var rtts map[KademliaID]time.Duration
+	if LookupRTTTieBreak {
+		rtts = node.knownRTTs()
+	}
+
 	for {
 		// Get the alpha closest contacts from the shortlist not contacted
-		alphaClosest := shortlist.GetClosestContactsNotContacted(node.Alpha, contacted)
+		var alphaClosest []*Contact
+		if rtts != nil {
+			alphaClosest = shortlist.GetFastestContactsNotContacted(node.Alpha, contacted, rtts)
+		} else {
+			alphaClosest = shortlist.GetClosestContactsNotContacted(node.Alpha, contacted)
+		}
 		responseChannel := make(chan []*Contact, len(alphaClosest))
+		failedChannel := make(chan *Contact, len(alphaClosest))
 		var wg sync.WaitGroup
 
 		if len(alphaClosest) == 0 {
-			return shortlist.GetClosestContacts(shortlist.Len())
+			return shortlist.GetClosestContacts(shortlist.Len()), stats
 		}
 
+		stats.Rounds++
+		stats.Queries += len(alphaClosest)
+
 		// Send asynchronous FindNode requests to the alpha closest (not contacted) contacts in the shortlist
 		for _, contact := range alphaClosest {
 			contacted[contact.Id] = true
@@ -68,14 +127,26 @@ func (node *Node) LookupContact(target *Contact) []*Contact {
 			wg.Add(1)
 			go func(c *Contact) {
 				defer wg.Done()
-				contacts, err := node.MessageHandler.SendFindNodeRequest(node.Me, c, target.Id)
+				contacts, err := node.MessageHandler.SendFindNodeRequest(node.GetMe(), c, target.Id)
 				if err != nil {
-					// Dead contacts are removed from the shortlist
-					shortlist.RemoveContact(c)
+					// Dead contacts are removed from the shortlist after the round
+					failedChannel <- c
+					return
+				}
+				if contacts.Payload == nil {
 					return
 				}
-				// Add the k closest contacts from the response to the shortlist
-				responseChannel <- contacts.Payload.Contacts
+				// Add the k closest valid contacts from the response to the shortlist
+				var valid []*Contact
+				for _, contact := range contacts.Payload.Contacts {
+					if len(valid) == node.K {
+						break
+					}
+					if ValidateContact(contact) && node.CanReach(contact) {
+						valid = append(valid, contact)
+					}
+				}
+				responseChannel <- valid
 			}(contact)
 		}
 		// Wait for all goroutines to finish
@@ -88,27 +159,80 @@ func (node *Node) LookupContact(target *Contact) []*Contact {
 		for contacts := range responseChannel {
 			for _, contact := range contacts {
 				// if the contact is me, skip
-				if !contact.Id.Equals(node.Me.Id) {
+				if !contact.Id.Equals(node.GetMe().Id) {
 					shortlist.AddContact(contact)
 				}
 			}
 		}
+		close(failedChannel)
+		for contact := range failedChannel {
+			shortlist.RemoveContact(contact)
+		}
 
 		// Check if all the contacts in the shortlist have been contacted
 		// or if the target is in the shortlist
 		// or if the closest contact has not changed
 		newClosestContact := shortlist.GetClosestContact()
 		if newClosestContact == nil {
-			return shortlist.GetClosestContacts(shortlist.Len())
+			return shortlist.GetClosestContacts(shortlist.Len()), stats
+		}
+		if !closestContact.Id.Equals(newClosestContact.Id) {
+			stats.Hops = stats.Rounds
 		}
 
 		if shortlist.AllContacted(contacted) || shortlist.Contains(target) || closestContact.Id.Equals(newClosestContact.Id) {
-			return shortlist.GetClosestContacts(shortlist.Len())
+			return shortlist.GetClosestContacts(shortlist.Len()), stats
 		}
 		closestContact = newClosestContact
 	}
 }
 
+// CanReach returns false if the contact has an address of an IP family
+// this node can not send to. Dual stack nodes can reach all contacts and
+// contacts with host names are resolved when sending. All contacts are
+// considered reachable unless FilterUnreachable is set, since a contact only
+// advertises one address and its peer may have one of our family as well
+func (node *Node) CanReach(contact *Contact) bool {
+	if !FilterUnreachable || DualStack || net.ParseIP(contact.Ip) == nil || net.ParseIP(node.GetMe().Ip) == nil {
+		return true
+	}
+	return contact.IsIPv6() == node.GetMe().IsIPv6()
+}
+
+// ReportObservedAddress records the address the peer responding from the responder
+// IP saw our request come from and changes the advertised IP address when peers in
+// a quorum of networks agree on a different one. The port is kept since requests are sent from other sockets
+// than the listener. The listener itself is not moved
+func (node *Node) ReportObservedAddress(responder string, ip string) {
+	if node.ExternalAddress == nil {
+		return
+	}
+	agreed := node.ExternalAddress.Report(responder, ip)
+	if agreed == "" {
+		return
+	}
+
+	node.MutexMe.Lock()
+	defer node.MutexMe.Unlock()
+	if agreed == node.Me.Ip {
+		return
+	}
+	fmt.Println("Changing advertised address from", node.Me.Ip, "to", agreed)
+	// Replace the contact instead of changing it so that readers
+	// holding the previous contact keep a consistent copy
+	me := *node.Me
+	me.Ip = agreed
+	node.Me = &me
+}
+
+// GetMe returns the contact of this node. The contact is replaced rather
+// than changed, so it must not be modified by the caller
+func (node *Node) GetMe() *Contact {
+	node.MutexMe.RLock()
+	defer node.MutexMe.RUnlock()
+	return node.Me
+}
+
 func (node *Node) LookupData(hash string) {
 	// TODO
 
@@ -121,14 +245,21 @@ func (node *Node) Store(data []byte) {
 func (node *Node) Join(contact *Contact) (err error) {
 	fmt.Println("Joining the network")
 	// Ping the contact to see if it is alive
-	_, e := node.MessageHandler.SendPingRequest(node.Me, contact)
+	response, e := node.MessageHandler.SendPingRequest(node.GetMe(), contact)
 	if e != nil {
 		return e
 	}
+	// Learn the public key of the contact from its response, so that the
+	// following requests to it can be encrypted
+	if response != nil && response.Source != nil && len(response.Source.PublicKey) > 0 && response.Source.Id.Equals(contact.Id) {
+		known := *contact
+		known.PublicKey = response.Source.PublicKey
+		contact = &known
+	}
 	// Add the contact to the routing table
 	node.RoutingTable.AddContact(contact)
 	// Perform a lookupNode on myself
-	contacts := node.LookupContact(node.Me)
+	contacts := node.LookupContact(node.GetMe())
 	// Update the routing table with the results
 	node.RoutingTable.UpdateRoutingTable(contacts)
 	// Refresh all buckets further away than the closest neighbor
@@ -140,13 +271,71 @@ func (node *Node) Join(contact *Contact) (err error) {
 // RefreshBuckets refreshes all buckets further away than the closest neighbor
 func (node *Node) RefreshBuckets() {
 	// Get the closest neighbor
-	neighbor := node.RoutingTable.FindClosestContacts(node.Me.Id)[0]
+	neighbor := node.RoutingTable.FindClosestContacts(node.GetMe().Id)[0]
 	// Get the bucket index of the neighbor
-	bucketIndex := node.RoutingTable.GetBucketIndex(neighbor.Id)
+	bucketIndex := GetBucketIndex(neighbor.Id, node.GetMe().Id)
 	// Refresh all buckets further away than the neighbor
 	for i := bucketIndex + 1; i < IDLength*8; i++ {
-		target := NewRandomKademliaIDInBucket(i, node.Me.Id)
+		target := node.RandomIDInBucket(i)
 		contacts := node.LookupContact(NewContact(target, "", 0))
 		node.RoutingTable.UpdateRoutingTable(contacts)
 	}
 }
+
+// RefreshStaleBuckets performs a lookup for a random KademliaID in every bucket
+// without activity since the given time and adds the results to the RoutingTable
+func (node *Node) RefreshStaleBuckets(since time.Time) {
+	for _, target := range node.RoutingTable.GetRefreshTargets(since) {
+		contacts := node.LookupContact(NewContact(target, "", 0))
+		node.RoutingTable.UpdateRoutingTable(contacts)
+	}
+}
+
+// RefreshLoop refreshes buckets that have been stale for the RefreshInterval,
+// checking every RefreshCheckInterval
+func (node *Node) RefreshLoop() {
+	ticker := time.NewTicker(RefreshCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		node.RefreshStaleBuckets(time.Now().Add(-RefreshInterval))
+	}
+}
+
+// RoutingTableStats returns the state of the buckets holding contacts and
+// the liveness of every contact, one per line
+func (node *Node) RoutingTableStats() string {
+	var stats strings.Builder
+	for _, bucket := range node.RoutingTable.GetBucketStats() {
+		if bucket.Contacts == 0 && bucket.Replacements == 0 {
+			continue
+		}
+		fmt.Fprintf(&stats, "bucket %s: %d contacts, %d replacements, last activity %s\n",
+			bucket.Prefix, bucket.Contacts, bucket.Replacements, bucket.LastActivity.Format(time.RFC3339))
+	}
+	for _, contact := range node.RoutingTable.GetContactStats() {
+		// Contacts only heard of from other nodes have never been seen
+		lastSeen := "never"
+		if !contact.LastSeen.IsZero() {
+			lastSeen = contact.LastSeen.Format(time.RFC3339)
+		}
+		fmt.Fprintf(&stats, "contact %s at %s: last seen %s, rtt %v, smoothed rtt %v, %d failures\n",
+			contact.Id, contact.Address, lastSeen, contact.LastRTT, contact.SmoothedRTT, contact.Failures)
+	}
+	return stats.String()
+}
+
+// StatsLoop logs the RoutingTableStats every StatsInterval
+func (node *Node) StatsLoop() {
+	ticker := time.NewTicker(StatsInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		fmt.Print(node.RoutingTableStats())
+	}
+}
+
+// RandomIDInBucket returns a random KademliaID that falls into the bucket of the
+// RoutingTable with the given index. Buckets are indexed by the highest differing
+// bit, so the ID shares all bits above it with our own ID
+func (node *Node) RandomIDInBucket(bucketIndex int) *KademliaID {
+	return NewRandomKademliaIDInBucket(IDLength*8-1-bucketIndex, node.GetMe().Id)
+}
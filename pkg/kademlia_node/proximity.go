@@ -0,0 +1,85 @@
+package kademlia_node
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// ProximitySelection also pings the candidates of a full bucket and lets the fastest
+	// one take the place of the slowest live contact if its smoothed round trip time is lower
+	ProximitySelection = false
+	// LookupRTTTieBreak makes lookups query the contacts with the lowest known round
+	// trip time first among the contacts sharing as many leading bits with the target
+	LookupRTTTieBreak = false
+)
+
+// pingReplacements pings the candidates and returns the round trip times of
+// the ones that answered, indexed like the candidates
+func (routingTable *RoutingTable) pingReplacements(candidates []Contact) []time.Duration {
+	rtts := make([]time.Duration, len(candidates))
+	var wg sync.WaitGroup
+	for i := range candidates {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sentAt := time.Now()
+			if _, err := routingTable.Node.MessageHandler.SendPingRequest(routingTable.Node.GetMe(), &candidates[i]); err == nil {
+				rtts[i] = time.Since(sentAt)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return rtts
+}
+
+// recordReplacementRTTs records the round trip times of the pinged candidates and
+// lets the fastest candidates replace slower contacts. Candidates that were promoted
+// or dropped from the replacement cache during the pings are skipped. The caller
+// must hold the lock
+func (routingTable *RoutingTable) recordReplacementRTTs(candidates []Contact, rtts []time.Duration) {
+	changed := make(map[*bucket]bool)
+	for i, rtt := range rtts {
+		// The bucket may have been split during the pings
+		replacements := routingTable.bucketFor(candidates[i].Id)
+		if rtt > 0 && replacements.RecordReplacementRTT(candidates[i], rtt) {
+			changed[replacements] = true
+		}
+	}
+	for replacements := range changed {
+		routingTable.preferFaster(replacements)
+	}
+}
+
+// preferFaster replaces the contact with the highest smoothed round trip time in
+// the bucket by the fastest candidate of the replacement cache within the diversity
+// limits if the candidate is faster. The replaced contact is still live and is kept
+// as a candidate. The caller must hold the lock
+func (routingTable *RoutingTable) preferFaster(replacements *bucket) {
+	slowest, found := replacements.GetSlowestContact()
+	if !found {
+		return
+	}
+	fastest, measured := replacements.GetFastestReplacement(func(bucket *bucket, candidate *Contact) bool {
+		return routingTable.isDiverseReplacing(bucket, candidate, &slowest)
+	})
+	if !measured || fastest.SmoothedRTT >= slowest.SmoothedRTT {
+		return
+	}
+	replacements.RemoveContact(slowest)
+	replacements.RemoveReplacement(fastest)
+	replacements.AddContact(fastest)
+	replacements.AddReplacement(slowest)
+}
+
+// knownRTTs returns the smoothed round trip times of the contacts in the routing table
+func (node *Node) knownRTTs() map[KademliaID]time.Duration {
+	rtts := make(map[KademliaID]time.Duration)
+	node.RoutingTable.ForEachContact(func(contact Contact) bool {
+		if contact.SmoothedRTT > 0 {
+			rtts[*contact.Id] = contact.SmoothedRTT
+		}
+		return true
+	})
+	return rtts
+}
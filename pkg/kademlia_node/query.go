@@ -0,0 +1,82 @@
+package kademlia_node
+
+import (
+	"time"
+)
+
+// ContactFilter returns true for the contacts a query should return
+type ContactFilter func(contact Contact) bool
+
+// ExcludeIDs returns a ContactFilter rejecting the contacts with one of the KademliaIDs
+func ExcludeIDs(ids ...*KademliaID) ContactFilter {
+	return func(contact Contact) bool {
+		for _, id := range ids {
+			if contact.Id.Equals(id) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// SeenSince returns a ContactFilter accepting the contacts seen since the given time
+func SeenSince(since time.Time) ContactFilter {
+	return func(contact Contact) bool {
+		return !contact.LastSeen.Before(since)
+	}
+}
+
+// AllOf returns a ContactFilter accepting the contacts accepted by all filters
+func AllOf(filters ...ContactFilter) ContactFilter {
+	return func(contact Contact) bool {
+		for _, filter := range filters {
+			if !filter(contact) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// FindClosestContactsFiltered returns copies of the count closest contacts to the target
+// accepted by the filter, or of all accepted contacts if count is not positive.
+// A nil filter accepts all contacts. The filter is called without holding the lock
+func (routingTable *RoutingTable) FindClosestContactsFiltered(target *KademliaID, count int, filter ContactFilter) []*Contact {
+	var candidates ContactCandidates
+	routingTable.ForEachContact(func(contact Contact) bool {
+		if filter == nil || filter(contact) {
+			contact.CalcDistance(target)
+			candidates.Append([]*Contact{&contact})
+		}
+		return true
+	})
+	candidates.Sort()
+	if count <= 0 {
+		count = candidates.Len()
+	}
+	return candidates.GetContacts(count)
+}
+
+// GetBucketCounts returns the number of contacts in every bucket, in the same order as GetBuckets
+func (routingTable *RoutingTable) GetBucketCounts() []int {
+	routingTable.Mutex.RLock()
+	defer routingTable.Mutex.RUnlock()
+
+	var counts []int
+	for _, bucket := range routingTable.buckets() {
+		counts = append(counts, bucket.Len())
+	}
+	return counts
+}
+
+// Size returns the number of contacts in the RoutingTable, without the replacement caches
+func (routingTable *RoutingTable) Size() int {
+	routingTable.Mutex.RLock()
+	defer routingTable.Mutex.RUnlock()
+
+	size := 0
+	for _, bucket := range routingTable.buckets() {
+		size += bucket.Len()
+	}
+	return size
+}
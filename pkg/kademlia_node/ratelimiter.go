@@ -0,0 +1,203 @@
+package kademlia_node
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// RateLimit is the budget of a token bucket, Rate tokens are added
+// per second up to a maximum of Burst tokens
+type RateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+var (
+	// RateLimits are the budgets of inbound requests per source and RPC type.
+	// Request types without a budget are not limited
+	RateLimits = map[RPCType]RateLimit{
+		PingRequest:      {Rate: 10, Burst: 20},
+		StoreRequest:     {Rate: 5, Burst: 10},
+		FindNodeRequest:  {Rate: 20, Burst: 40},
+		FindValueRequest: {Rate: 20, Burst: 40},
+	}
+	BanThreshold      = 100             // Number of exceeded budgets within the BanWindow before a source is banned, 0 disables bans
+	BanWindow         = time.Minute     // Window in which exceeded budgets are counted
+	BanDuration       = 5 * time.Minute // Duration of a ban
+	MaxTrackedSources = 10000           // Number of token buckets kept, idle ones are pruned first and then the least recently used
+)
+
+// tokenBucket holds the tokens of a single source and RPC type
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// violations counts the exceeded budgets of a source
+type violations struct {
+	count int
+	start time.Time
+}
+
+// RateLimiter limits inbound requests per source IP and per source node ID
+// using token buckets, and temporarily bans sources that repeatedly exceed
+// their budgets. Node IDs can be claimed by anyone, so they should only be
+// given for authenticated messages
+type RateLimiter struct {
+	Limits     map[RPCType]RateLimit
+	buckets    map[string]*list.Element
+	order      *list.List // Token buckets, most recently used first
+	violations map[string]*violations
+	bans       map[string]time.Time
+	Mutex      sync.Mutex
+}
+
+// NewRateLimiter returns a new instance of a RateLimiter
+func NewRateLimiter(limits map[RPCType]RateLimit) *RateLimiter {
+	return &RateLimiter{
+		Limits:     limits,
+		buckets:    make(map[string]*list.Element),
+		order:      list.New(),
+		violations: make(map[string]*violations),
+		bans:       make(map[string]time.Time),
+	}
+}
+
+// Allow returns true if a request of the given type from the source
+// IP and node ID is within budget. The node ID may be nil, in which
+// case only the IP is limited
+func (limiter *RateLimiter) Allow(ip string, id *KademliaID, rpcType RPCType) bool {
+	return limiter.AllowAt(ip, id, rpcType, time.Now())
+}
+
+// AllowAt is Allow at the given point in time
+func (limiter *RateLimiter) AllowAt(ip string, id *KademliaID, rpcType RPCType, now time.Time) bool {
+	limiter.Mutex.Lock()
+	defer limiter.Mutex.Unlock()
+
+	sources := []string{"ip:" + ip}
+	if id != nil {
+		sources = append(sources, "id:"+id.String())
+	}
+
+	for _, source := range sources {
+		if limiter.isBanned(source, now) {
+			return false
+		}
+	}
+
+	limit, limited := limiter.Limits[rpcType]
+	if !limited {
+		return true
+	}
+
+	// Both the IP and the node ID must be within budget, a token is only
+	// taken from either bucket when both have one
+	allowed := true
+	buckets := make([]*tokenBucket, len(sources))
+	for i, source := range sources {
+		buckets[i] = limiter.refill(source+":"+string(rpcType), limit, now)
+		if buckets[i].tokens < 1 {
+			allowed = false
+			limiter.recordViolation(source, now)
+		}
+	}
+	if allowed {
+		for _, bucket := range buckets {
+			bucket.tokens--
+		}
+	}
+	return allowed
+}
+
+// IsBanned returns true if the source IP or node ID is currently banned
+func (limiter *RateLimiter) IsBanned(ip string, id *KademliaID) bool {
+	limiter.Mutex.Lock()
+	defer limiter.Mutex.Unlock()
+	now := time.Now()
+	return limiter.isBanned("ip:"+ip, now) || (id != nil && limiter.isBanned("id:"+id.String(), now))
+}
+
+// Len returns the number of tracked token buckets
+func (limiter *RateLimiter) Len() int {
+	limiter.Mutex.Lock()
+	defer limiter.Mutex.Unlock()
+	return len(limiter.buckets)
+}
+
+// isBanned returns true if the source is banned, expired bans are removed
+func (limiter *RateLimiter) isBanned(source string, now time.Time) bool {
+	until, banned := limiter.bans[source]
+	if !banned {
+		return false
+	}
+	if now.After(until) {
+		delete(limiter.bans, source)
+		return false
+	}
+	return true
+}
+
+// refill returns the bucket with the given key with the tokens added since its
+// last request. A full limiter drops idle buckets, and then the least recently used
+func (limiter *RateLimiter) refill(key string, limit RateLimit, now time.Time) *tokenBucket {
+	element, exists := limiter.buckets[key]
+	if !exists {
+		if len(limiter.buckets) >= MaxTrackedSources {
+			limiter.prune(now)
+		}
+		for len(limiter.buckets) >= MaxTrackedSources {
+			oldest := limiter.order.Back()
+			delete(limiter.buckets, oldest.Value.(*tokenBucket).key)
+			limiter.order.Remove(oldest)
+		}
+		element = limiter.order.PushFront(&tokenBucket{key: key, tokens: limit.Burst, last: now})
+		limiter.buckets[key] = element
+	}
+	limiter.order.MoveToFront(element)
+
+	// Refill the bucket for the time passed since the last request
+	bucket := element.Value.(*tokenBucket)
+	bucket.tokens += now.Sub(bucket.last).Seconds() * limit.Rate
+	if bucket.tokens > limit.Burst {
+		bucket.tokens = limit.Burst
+	}
+	bucket.last = now
+	return bucket
+}
+
+// recordViolation counts an exceeded budget and bans the source when
+// it exceeds its budgets too often
+func (limiter *RateLimiter) recordViolation(source string, now time.Time) {
+	if BanThreshold <= 0 {
+		return
+	}
+	v, exists := limiter.violations[source]
+	if !exists || now.Sub(v.start) > BanWindow {
+		v = &violations{start: now}
+		limiter.violations[source] = v
+	}
+	v.count++
+	if v.count >= BanThreshold {
+		limiter.bans[source] = now.Add(BanDuration)
+		delete(limiter.violations, source)
+	}
+}
+
+// prune removes the token buckets and violations of sources that have
+// been idle for longer than the BanWindow
+func (limiter *RateLimiter) prune(now time.Time) {
+	for key, element := range limiter.buckets {
+		if now.Sub(element.Value.(*tokenBucket).last) > BanWindow {
+			delete(limiter.buckets, key)
+			limiter.order.Remove(element)
+		}
+	}
+	for source, v := range limiter.violations {
+		if now.Sub(v.start) > BanWindow {
+			delete(limiter.violations, source)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package kademlia_node
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+var (
+	ResponseCacheTTL  = 30 * time.Second // How long responses are kept, longer than a request with all its retries
+	ResponseCacheSize = 1000             // Maximum number of cached responses
+)
+
+// cachedResponse is the response to a request, nil while the request is processed
+type cachedResponse struct {
+	key      string
+	response *RPC
+	sent     bool // Whether the response was sent to the requester
+	expires  time.Time
+}
+
+// ResponseCache remembers the responses to recent requests so that
+// duplicate or retried requests are answered without processing them again
+type ResponseCache struct {
+	entries map[string]*list.Element
+	order   *list.List
+	Mutex   sync.Mutex
+}
+
+// NewResponseCache returns a new instance of a ResponseCache
+func NewResponseCache() *ResponseCache {
+	return &ResponseCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Begin marks the request as being processed. If the request has been seen
+// before it returns true together with its response, which is nil if the
+// first request is still being processed, and whether the response was sent
+func (cache *ResponseCache) Begin(rpc *RPC) (*RPC, bool, bool) {
+	cache.Mutex.Lock()
+	defer cache.Mutex.Unlock()
+
+	now := time.Now()
+	key := cacheKey(rpc)
+	if element, exists := cache.entries[key]; exists {
+		entry := element.Value.(*cachedResponse)
+		if now.Before(entry.expires) {
+			return entry.response, entry.sent, true
+		}
+		cache.remove(element)
+	}
+
+	cache.evict(now)
+	cache.entries[key] = cache.order.PushBack(&cachedResponse{key: key, expires: now.Add(ResponseCacheTTL)})
+	return nil, false, false
+}
+
+// Complete stores the response to a request marked with Begin and whether
+// it was sent, so that duplicates are answered the same way
+func (cache *ResponseCache) Complete(rpc *RPC, response *RPC, sent bool) {
+	cache.Mutex.Lock()
+	defer cache.Mutex.Unlock()
+
+	if element, exists := cache.entries[cacheKey(rpc)]; exists {
+		entry := element.Value.(*cachedResponse)
+		entry.response = response
+		entry.sent = sent
+	}
+}
+
+// Abort forgets a request marked with Begin so that it can be processed again
+func (cache *ResponseCache) Abort(rpc *RPC) {
+	cache.Mutex.Lock()
+	defer cache.Mutex.Unlock()
+
+	if element, exists := cache.entries[cacheKey(rpc)]; exists {
+		cache.remove(element)
+	}
+}
+
+// Len returns the number of cached requests
+func (cache *ResponseCache) Len() int {
+	cache.Mutex.Lock()
+	defer cache.Mutex.Unlock()
+	return cache.order.Len()
+}
+
+// evict removes expired entries and the oldest entries if the cache is full.
+// The caller must hold the lock
+func (cache *ResponseCache) evict(now time.Time) {
+	for element := cache.order.Front(); element != nil; element = cache.order.Front() {
+		if cache.order.Len() < ResponseCacheSize && now.Before(element.Value.(*cachedResponse).expires) {
+			return
+		}
+		cache.remove(element)
+	}
+}
+
+// remove deletes the entry of the element. The caller must hold the lock
+func (cache *ResponseCache) remove(element *list.Element) {
+	delete(cache.entries, element.Value.(*cachedResponse).key)
+	cache.order.Remove(element)
+}
+
+// cacheKey identifies a request by its source, RPC ID and type
+func cacheKey(rpc *RPC) string {
+	return rpc.Source.Id.String() + ":" + rpc.ID.String() + ":" + string(rpc.Type)
+}
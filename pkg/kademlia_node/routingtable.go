@@ -3,18 +3,93 @@ package kademlia_node
 import (
 	"math/bits"
 	"sync"
+	"time"
 )
 
+// FailureThreshold is the number of consecutive failed requests
+// after which a contact is evicted from the routing table
+var FailureThreshold = 3
+
+// Limits on the contacts sharing an IP address or a /24 (/64 for IPv6) network,
+// so that a single host can not fill the routing table. Zero disables a limit
+var (
+	MaxSameIPPerBucket     = 0
+	MaxSameSubnetPerBucket = 0
+	MaxSameIPPerTable      = 0
+	MaxSameSubnetPerTable  = 0
+)
+
+// RoutingTableInterface is implemented by the routing tables a Node can use,
+// so that lookups and message handling do not depend on how buckets are kept
+type RoutingTableInterface interface {
+	AddContact(contact *Contact)
+	RemoveContact(contact *Contact)
+	RecordFailure(contact *Contact)
+	RecordSuccess(contact *Contact, rtt time.Duration)
+	FindClosestContacts(target *KademliaID) []*Contact
+	FindClosestContactsFiltered(target *KademliaID, count int, filter ContactFilter) []*Contact
+	UpdateRoutingTable(contacts []*Contact)
+	Touch(id *KademliaID)
+	GetRefreshTargets(since time.Time) []*KademliaID
+	GetBucketStats() []BucketStats
+	ForEachContact(fn func(contact Contact) bool)
+	GetContactStats() []ContactStats
+	GetBucketCounts() []int
+	Size() int
+	RestoreContact(contact *Contact)
+}
+
+// BucketStats describes the state of a bucket of a routing table
+type BucketStats struct {
+	Prefix       string // Bits shared by the IDs in the bucket
+	Contacts     int
+	Replacements int
+	LastActivity time.Time
+}
+
+// ContactStats describes the liveness of a contact of a routing table
+type ContactStats struct {
+	Id          string
+	Address     string
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	LastRTT     time.Duration
+	SmoothedRTT time.Duration
+	Failures    int
+}
+
 type RoutingTable struct {
-	Node    *Node
-	Buckets []*bucket
-	Mutex   sync.RWMutex
+	Node     *Node
+	Buckets  []*bucket   // Fixed buckets indexed by GetBucketIndex, nil when splitting buckets
+	Root     *treeNode   // Tree of buckets when splitting buckets, nil otherwise
+	Relaxed  bool        // Also split buckets not covering our own ID, see RelaxedSplitting
+	Digits   [][]*bucket // Buckets indexed by digit position and value when DigitBits > 1, nil otherwise
+	Bits     int         // Bits per digit of Digits
+	Mutex    sync.RWMutex
+	Evicting map[*bucket]bool // Buckets whose least recently seen contact is being pinged
+	Evicted  *sync.Cond       // Signalled when an eviction has been applied
+	Counters *Counters
 }
 
-// NewRoutingTable returns a new instance of a RoutingTable
+// NewRoutingTable returns a new instance of a RoutingTable. With DigitBits > 1
+// the table has 2^DigitBits-1 buckets per digit, with BucketSplitting it starts
+// with a single bucket, otherwise with IDLength*8 fixed buckets
 func NewRoutingTable(node *Node) *RoutingTable {
 	routingTable := &RoutingTable{
-		Node: node}
+		Node:     node,
+		Evicting: make(map[*bucket]bool),
+		Counters: NewCounters()}
+	routingTable.Evicted = sync.NewCond(&routingTable.Mutex)
+	if DigitBits > 1 {
+		routingTable.Bits = DigitBits
+		routingTable.Digits = newDigitBuckets(node.GetMe().Id, DigitBits, node.K)
+		return routingTable
+	}
+	if BucketSplitting {
+		routingTable.Root = newTreeNode(KademliaID{}, 0, node.K)
+		routingTable.Relaxed = RelaxedSplitting
+		return routingTable
+	}
 	routingTable.Buckets = make([]*bucket, IDLength*8)
 	for i := 0; i < IDLength*8; i++ {
 		routingTable.Buckets[i] = NewBucket(node.K)
@@ -22,39 +97,292 @@ func NewRoutingTable(node *Node) *RoutingTable {
 	return routingTable
 }
 
-// AddContact add a new contact to the correct Bucket
+// bucketFor returns the bucket covering the KademliaID,
+// or nil if the KademliaID is our own in a table with fixed buckets or digits
+func (routingTable *RoutingTable) bucketFor(id *KademliaID) *bucket {
+	if routingTable.Root != nil {
+		return routingTable.Root.Leaf(id).Bucket
+	}
+	if routingTable.Digits != nil {
+		position, value := digitBucketFor(id, routingTable.Node.GetMe().Id, routingTable.Bits)
+		if position == -1 {
+			return nil
+		}
+		return routingTable.Digits[position][value]
+	}
+	bucketIndex := routingTable.GetBucketIndex(id)
+	if bucketIndex == -1 {
+		return nil
+	}
+	return routingTable.Buckets[bucketIndex]
+}
+
+// isDiverse returns false and counts the rejection if adding the contact would exceed
+// the limits on contacts sharing an IP address or network. The caller must hold the lock
+func (routingTable *RoutingTable) isDiverse(bucket *bucket, contact *Contact) bool {
+	return routingTable.isDiverseReplacing(bucket, contact, nil)
+}
+
+// isDiverseReplacing is isDiverse for a contact that takes the place of the
+// replaced contact, which is not counted. The caller must hold the lock
+func (routingTable *RoutingTable) isDiverseReplacing(bucket *bucket, contact *Contact, replaced *Contact) bool {
+	subnet := contact.Subnet()
+	if subnet == "" {
+		return true
+	}
+	sameIP, sameSubnet := countSameHost(bucket, contact.Ip, subnet, replaced)
+	if exceeds(sameIP, MaxSameIPPerBucket) {
+		routingTable.Counters.Increment(CounterSameIPRejected)
+		return false
+	}
+	if exceeds(sameSubnet, MaxSameSubnetPerBucket) {
+		routingTable.Counters.Increment(CounterSameSubnetRejected)
+		return false
+	}
+	if MaxSameIPPerTable <= 0 && MaxSameSubnetPerTable <= 0 {
+		return true
+	}
+	sameIP, sameSubnet = 0, 0
+	for _, other := range routingTable.buckets() {
+		ip, network := countSameHost(other, contact.Ip, subnet, replaced)
+		sameIP += ip
+		sameSubnet += network
+	}
+	if exceeds(sameIP, MaxSameIPPerTable) {
+		routingTable.Counters.Increment(CounterSameIPRejected)
+		return false
+	}
+	if exceeds(sameSubnet, MaxSameSubnetPerTable) {
+		routingTable.Counters.Increment(CounterSameSubnetRejected)
+		return false
+	}
+	return true
+}
+
+// countSameHost returns the number of contacts in the bucket with the IP address
+// and in the subnet, leaving out the excluded contact if it is not nil
+func countSameHost(bucket *bucket, ip string, subnet string, excluded *Contact) (int, int) {
+	sameIP, sameSubnet := 0, 0
+	for elt := bucket.List.Front(); elt != nil; elt = elt.Next() {
+		contact := elt.Value.(Contact)
+		if excluded != nil && contact.Id.Equals(excluded.Id) {
+			continue
+		}
+		if contact.Ip == ip {
+			sameIP++
+		}
+		if contact.Subnet() == subnet {
+			sameSubnet++
+		}
+	}
+	return sameIP, sameSubnet
+}
+
+// exceeds returns true if one more contact would exceed the limit
+func exceeds(count int, limit int) bool {
+	return limit > 0 && count >= limit
+}
+
+// canSplit returns true if the full bucket the contact belongs to may be split.
+// Buckets covering our own ID are always split, other buckets only with
+// the relaxed rule if the contact is among the K closest to our own ID
+func (routingTable *RoutingTable) canSplit(contact *Contact) bool {
+	if routingTable.Root == nil {
+		return false
+	}
+	leaf := routingTable.Root.Leaf(contact.Id)
+	if leaf.Depth >= IDLength*8 {
+		return false
+	}
+	if leaf.Covers(routingTable.Node.GetMe().Id) {
+		return true
+	}
+	return routingTable.Relaxed && routingTable.closerContacts(contact.Distance) < routingTable.Node.K
+}
+
+// closerContacts returns the number of contacts closer to our own ID than the distance
+func (routingTable *RoutingTable) closerContacts(distance *KademliaID) int {
+	closer := 0
+	for _, leaf := range routingTable.Root.Leaves() {
+		for elt := leaf.Bucket.List.Front(); elt != nil; elt = elt.Next() {
+			if elt.Value.(Contact).Id.CalcDistance(routingTable.Node.GetMe().Id).Less(distance) {
+				closer++
+			}
+		}
+	}
+	return closer
+}
+
+// AddContact adds a contact that sent us a request or answered one to the correct
+// Bucket. A contact already in the bucket is moved to the front with its newest
+// address and key, and its failures are cleared
 func (routingTable *RoutingTable) AddContact(contact *Contact) {
+	routingTable.addContact(contact, true)
+}
+
+// addContact adds the contact to the correct Bucket. Contacts only heard of
+// from other nodes are inserted if they are new and never refresh a contact
+// already in the bucket, so that a dead contact still advertised by others
+// keeps its failures and gets evicted
+func (routingTable *RoutingTable) addContact(contact *Contact, seen bool) {
 	routingTable.Mutex.Lock()
 	defer routingTable.Mutex.Unlock()
 
-	// Update the distance of the contact
-	contact.CalcDistance(routingTable.Node.Me.Id)
-
-	bucketIndex := routingTable.GetBucketIndex(contact.Id)
+	// Work on a copy of the contact, the caller's contact is never changed
+	// since it may be sent concurrently by pings of full buckets
+	added := *contact
+	added.CalcDistance(routingTable.Node.GetMe().Id)
+	if added.Id.Equals(routingTable.Node.GetMe().Id) {
+		return
+	}
+	// Only a request from or a response by the contact counts as seeing it
+	added.FirstSeen = time.Now()
+	added.LastSeen = time.Time{}
+	if seen {
+		added.LastSeen = added.FirstSeen
+	}
+	added.Failures = 0
 
-	bucket := routingTable.Buckets[bucketIndex]
+	bucket := routingTable.bucketFor(added.Id)
+	bucket.LastActivity = time.Now()
+	if bucket.Contains(added) {
+		if seen {
+			bucket.AddContact(added)
+			bucket.RecordSuccess(added, 0)
+		}
+		return
+	}
+	// Reject contacts from hosts and networks that already have enough contacts
+	if !routingTable.isDiverse(bucket, &added) {
+		return
+	}
+	// Split full buckets until the contact fits or its bucket may not be split
+	for bucket.Len() >= routingTable.Node.K && routingTable.canSplit(&added) {
+		routingTable.Root.Leaf(added.Id).Split(routingTable.isDiverse)
+		bucket = routingTable.bucketFor(added.Id)
+	}
 	// Check if the bucket is full
 	if bucket.Len() >= routingTable.Node.K {
-		// Ping the least recently seen contact
-		leastRecent := bucket.GetLeastRecentlySeenContact()
-		_, err := routingTable.Node.MessageHandler.SendPingRequest(routingTable.Node.Me, &leastRecent)
-		if err == nil {
-			// If the contact is still alive, move it to the front of the bucket
+		// Queue the new contact as a replacement and ping the least recently
+		// seen contact without holding the lock, since the ping can take
+		// the full timeout
+		bucket.AddReplacement(added)
+		if !routingTable.Evicting[bucket] {
+			routingTable.Evicting[bucket] = true
+			var candidates []Contact
+			if ProximitySelection {
+				candidates = bucket.GetReplacements()
+			}
+			go routingTable.evict(bucket, bucket.GetLeastRecentlySeenContact(), candidates)
+		}
+		return
+	}
+	bucket.RemoveReplacement(added)
+	bucket.AddContact(added)
+}
+
+// evict pings the least recently seen contact of a full bucket. A live contact
+// is moved to the front of the bucket, a dead one is replaced by the most
+// recently seen candidate of the replacement cache. With ProximitySelection
+// the candidates are pinged as well and the fastest may replace a slower live contact
+func (routingTable *RoutingTable) evict(pinged *bucket, leastRecent Contact, candidates []Contact) {
+	_, err := routingTable.Node.MessageHandler.SendPingRequest(routingTable.Node.GetMe(), &leastRecent)
+	var rtts []time.Duration
+	if err == nil && ProximitySelection {
+		rtts = routingTable.pingReplacements(candidates)
+	}
+
+	routingTable.Mutex.Lock()
+	defer routingTable.Mutex.Unlock()
+	delete(routingTable.Evicting, pinged)
+	routingTable.Evicted.Broadcast()
+
+	// The bucket may have been split during the ping
+	bucket := routingTable.bucketFor(leastRecent.Id)
+	if err == nil {
+		// Only refresh the contact if it was not removed during the ping
+		if bucket.Contains(leastRecent) {
 			bucket.AddContact(leastRecent)
-		} else {
-			// If the contact is not alive, remove it from the bucket
-			bucket.RemoveContact(leastRecent)
 		}
+		routingTable.recordReplacementRTTs(candidates, rtts)
+		return
 	}
-	bucket.AddContact(*contact)
+	bucket.RemoveContact(leastRecent)
+	bucket.PromoteReplacement(routingTable.isDiverse)
 }
 
-// FindClosestContacts finds the count closest Contacts to the target in the RoutingTable
-func (routingTable *RoutingTable) FindClosestContacts(target *KademliaID) []*Contact {
+// WaitForEvictions blocks until the pings of all full buckets have been answered
+// and the resulting evictions have been applied
+func (routingTable *RoutingTable) WaitForEvictions() {
 	routingTable.Mutex.Lock()
 	defer routingTable.Mutex.Unlock()
+	for len(routingTable.Evicting) > 0 {
+		routingTable.Evicted.Wait()
+	}
+}
+
+// RemoveContact removes a failed contact from its bucket and promotes
+// the most recently seen candidate of the replacement cache in its place
+func (routingTable *RoutingTable) RemoveContact(contact *Contact) {
+	routingTable.Mutex.Lock()
+	defer routingTable.Mutex.Unlock()
+
+	bucket := routingTable.bucketFor(contact.Id)
+	if bucket == nil {
+		return
+	}
+	if !bucket.Contains(*contact) {
+		bucket.RemoveReplacement(*contact)
+		return
+	}
+	bucket.RemoveContact(*contact)
+	bucket.PromoteReplacement(routingTable.isDiverse)
+}
+
+// RecordFailure counts a failed request to the contact. Contacts that have
+// failed FailureThreshold times in a row are removed from their bucket and
+// replaced by the most recently seen candidate of the replacement cache
+func (routingTable *RoutingTable) RecordFailure(contact *Contact) {
+	routingTable.Mutex.Lock()
+	defer routingTable.Mutex.Unlock()
+
+	bucket := routingTable.bucketFor(contact.Id)
+	if bucket == nil {
+		return
+	}
+	if bucket.RecordFailure(*contact) >= FailureThreshold {
+		bucket.RemoveContact(*contact)
+		bucket.PromoteReplacement(routingTable.isDiverse)
+	}
+}
+
+// RecordSuccess marks the contact as seen, clears its failures and
+// records the round trip time of the request after a successful exchange
+func (routingTable *RoutingTable) RecordSuccess(contact *Contact, rtt time.Duration) {
+	routingTable.Mutex.Lock()
+	defer routingTable.Mutex.Unlock()
+
+	if bucket := routingTable.bucketFor(contact.Id); bucket != nil {
+		bucket.RecordSuccess(*contact, rtt)
+	}
+}
+
+// FindClosestContacts finds the count closest Contacts to the target in the RoutingTable
+func (routingTable *RoutingTable) FindClosestContacts(target *KademliaID) []*Contact {
+	routingTable.Mutex.RLock()
+	defer routingTable.Mutex.RUnlock()
 
 	var candidates ContactCandidates
+	if routingTable.Root != nil || routingTable.Digits != nil {
+		// Trees have few buckets and the closest contacts of digit tables are spread
+		// over the buckets of several digits, so all contacts are considered
+		for _, bucket := range routingTable.buckets() {
+			candidates.Append(bucket.GetContactsAndCalcDistance(target))
+		}
+		candidates.Sort()
+		return candidates.GetContacts(routingTable.Node.K)
+	}
+
 	bucketIndex := routingTable.GetBucketIndex(target)
 
 	// If the target is the node itself, start from the first bucket
@@ -81,10 +409,174 @@ func (routingTable *RoutingTable) FindClosestContacts(target *KademliaID) []*Con
 	return candidates.GetContacts(candidates.Len())
 }
 
+// Touch marks the bucket covering the KademliaID as active, e.g. because a lookup
+// for the KademliaID has been performed
+func (routingTable *RoutingTable) Touch(id *KademliaID) {
+	routingTable.Mutex.Lock()
+	defer routingTable.Mutex.Unlock()
+
+	if bucket := routingTable.bucketFor(id); bucket != nil {
+		bucket.LastActivity = time.Now()
+	}
+}
+
+// GetRefreshTargets returns a random KademliaID in every bucket
+// without activity since the given time
+func (routingTable *RoutingTable) GetRefreshTargets(since time.Time) []*KademliaID {
+	routingTable.Mutex.RLock()
+	defer routingTable.Mutex.RUnlock()
+
+	var targets []*KademliaID
+	if routingTable.Root != nil {
+		for _, leaf := range routingTable.Root.Leaves() {
+			if leaf.Bucket.LastActivity.Before(since) {
+				targets = append(targets, leaf.RandomID())
+			}
+		}
+		return targets
+	}
+	if routingTable.Digits != nil {
+		routingTable.forEachDigitBucket(func(bucket *bucket, prefix KademliaID, length int) {
+			if bucket.LastActivity.Before(since) {
+				targets = append(targets, randomIDWithPrefix(&prefix, length))
+			}
+		})
+		return targets
+	}
+	for i, bucket := range routingTable.Buckets {
+		if bucket.LastActivity.Before(since) {
+			targets = append(targets, routingTable.Node.RandomIDInBucket(i))
+		}
+	}
+	return targets
+}
+
+// GetBuckets returns the buckets of the RoutingTable, in order of
+// their index or for trees and digit tables in order of their prefix
+func (routingTable *RoutingTable) GetBuckets() []*bucket {
+	routingTable.Mutex.RLock()
+	defer routingTable.Mutex.RUnlock()
+	return routingTable.buckets()
+}
+
+// GetBucketStats returns the state of every bucket, in the same order as GetBuckets
+func (routingTable *RoutingTable) GetBucketStats() []BucketStats {
+	routingTable.Mutex.RLock()
+	defer routingTable.Mutex.RUnlock()
+
+	var stats []BucketStats
+	if routingTable.Root != nil {
+		for _, leaf := range routingTable.Root.Leaves() {
+			stats = append(stats, newBucketStats(leaf.Bucket, leaf.PrefixString()))
+		}
+		return stats
+	}
+	if routingTable.Digits != nil {
+		routingTable.forEachDigitBucket(func(bucket *bucket, prefix KademliaID, length int) {
+			stats = append(stats, newBucketStats(bucket, prefixString(&prefix, length)))
+		})
+		return stats
+	}
+	for i, bucket := range routingTable.Buckets {
+		// The IDs of bucket i differ from our own ID first in the bit at IDLength*8-1-i
+		depth := IDLength*8 - 1 - i
+		prefix := prefixString(routingTable.Node.GetMe().Id, depth) + string("10"[bit(routingTable.Node.GetMe().Id, depth)])
+		stats = append(stats, newBucketStats(bucket, prefix))
+	}
+	return stats
+}
+
+// newBucketStats returns the state of the bucket
+func newBucketStats(bucket *bucket, prefix string) BucketStats {
+	return BucketStats{
+		Prefix:       prefix,
+		Contacts:     bucket.Len(),
+		Replacements: bucket.Replacements.Len(),
+		LastActivity: bucket.LastActivity,
+	}
+}
+
+// ForEachContact calls fn for a deep copy of every contact in the RoutingTable until
+// fn returns false. The contacts are copied first, so fn may use the RoutingTable
+// and change the contacts
+func (routingTable *RoutingTable) ForEachContact(fn func(contact Contact) bool) {
+	routingTable.Mutex.RLock()
+	var contacts []Contact
+	for _, bucket := range routingTable.buckets() {
+		for elt := bucket.List.Front(); elt != nil; elt = elt.Next() {
+			contacts = append(contacts, elt.Value.(Contact).clone())
+		}
+	}
+	routingTable.Mutex.RUnlock()
+
+	for _, contact := range contacts {
+		if !fn(contact) {
+			return
+		}
+	}
+}
+
+// GetContactStats returns the liveness of every contact in the RoutingTable,
+// so that contacts that are slow or keep failing can be found
+func (routingTable *RoutingTable) GetContactStats() []ContactStats {
+	var stats []ContactStats
+	routingTable.ForEachContact(func(contact Contact) bool {
+		stats = append(stats, ContactStats{
+			Id:          contact.Id.String(),
+			Address:     contact.Address(),
+			FirstSeen:   contact.FirstSeen,
+			LastSeen:    contact.LastSeen,
+			LastRTT:     contact.LastRTT,
+			SmoothedRTT: contact.SmoothedRTT,
+			Failures:    contact.Failures,
+		})
+		return true
+	})
+	return stats
+}
+
+// buckets returns the buckets of the RoutingTable. The caller must hold the lock
+func (routingTable *RoutingTable) buckets() []*bucket {
+	if routingTable.Root != nil {
+		var buckets []*bucket
+		for _, leaf := range routingTable.Root.Leaves() {
+			buckets = append(buckets, leaf.Bucket)
+		}
+		return buckets
+	}
+	if routingTable.Digits != nil {
+		var buckets []*bucket
+		routingTable.forEachDigitBucket(func(bucket *bucket, _ KademliaID, _ int) {
+			buckets = append(buckets, bucket)
+		})
+		return buckets
+	}
+	return append([]*bucket(nil), routingTable.Buckets...)
+}
+
+// forEachDigitBucket calls fn for every bucket of a digit table with the prefix shared
+// by the IDs in the bucket and its length in bits. The caller must hold the lock
+func (routingTable *RoutingTable) forEachDigitBucket(fn func(bucket *bucket, prefix KademliaID, length int)) {
+	for position, buckets := range routingTable.Digits {
+		for value, bucket := range buckets {
+			if bucket != nil {
+				prefix, length := digitPrefix(routingTable.Node.GetMe().Id, position, value, routingTable.Bits)
+				fn(bucket, prefix, length)
+			}
+		}
+	}
+}
 
 // GetBucketIndex get the correct Bucket index for the KademliaID
 func (routingTable *RoutingTable) GetBucketIndex(id *KademliaID) int {
-	distance := id.CalcDistance(routingTable.Node.Me.Id)
+	return GetBucketIndex(id, routingTable.Node.GetMe().Id)
+}
+
+// GetBucketIndex returns the index of the fixed bucket the KademliaID falls into
+// in the routing table of the node with the reference ID, or -1 for the reference ID.
+// The index is the position of the highest bit in which the IDs differ
+func GetBucketIndex(id *KademliaID, referenceID *KademliaID) int {
+	distance := id.CalcDistance(referenceID)
 	leadingZeros := 0
 
 	for i := 0; i < IDLength; i++ {
@@ -99,9 +591,10 @@ func (routingTable *RoutingTable) GetBucketIndex(id *KademliaID) int {
 	return IDLength*8 - leadingZeros - 1
 }
 
-// UpdateRoutingTable updates the RoutingTable with a list of new contacts
+// UpdateRoutingTable adds the new contacts of a list heard of from other nodes
+// to the RoutingTable. Contacts already in the RoutingTable are left as they are
 func (routingTable *RoutingTable) UpdateRoutingTable(contacts []*Contact) {
 	for _, contact := range contacts {
-		routingTable.AddContact(contact)
+		routingTable.addContact(contact, false)
 	}
 }
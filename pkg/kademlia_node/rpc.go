@@ -2,21 +2,40 @@ package kademlia_node
 
 import (
 	"fmt"
+	"net"
 )
 
+// ProtocolVersion is the version of the wire protocol spoken by this node.
+// Version 2 encodes KademliaIDs as hex strings of IDLength bytes
+const ProtocolVersion = 2
+
+// NetworkID identifies the DHT this node belongs to, nodes only talk to
+// peers with the same network ID
+var NetworkID = "kadlab"
+
+// Header identifies the protocol version, the network and the ID length of an RPC
+type Header struct {
+	Version   int    `json:"Version"`
+	NetworkID string `json:"NetworkID"`
+	IDLength  int    `json:"IDLength"`
+}
+
 type RPC struct {
-	ID          *KademliaID `json:"ID"`
-	Type        RPCType     `json:"Type"`
-	IsResponse  bool        `json:"IsResponse"`
-	Destination *Contact    `json:"Destination"`
-	Source      *Contact    `json:"Source"`
-	Payload     *Payload    `json:"Payload"`
+	Header      *Header      `json:"Header"`
+	ID          *KademliaID  `json:"ID"`
+	Type        RPCType      `json:"Type"`
+	IsResponse  bool         `json:"IsResponse"`
+	Destination *Contact     `json:"Destination"`
+	Source      *Contact     `json:"Source"`
+	Payload     *Payload     `json:"Payload"`
+	From        *net.UDPAddr `json:"-"` // Address the RPC was received from
 }
 
 type Payload struct {
-	Key      *KademliaID
-	Data     []byte
-	Contacts []*Contact
+	Key        *KademliaID
+	Data       []byte
+	Contacts   []*Contact
+	ObservedIp string `json:",omitempty"` // IP address a PING request was received from
 }
 
 type RPCType string
@@ -33,12 +52,36 @@ const (
 	FindValueResponse RPCType = "FIND_VALUE_RESPONSE"
 )
 
+// NewHeader returns a header for the current protocol version and network
+func NewHeader() *Header {
+	return &Header{Version: ProtocolVersion, NetworkID: NetworkID, IDLength: IDLength}
+}
+
 func NewPayload(Key *KademliaID, Data []byte, Contacts []*Contact) *Payload {
 	return &Payload{Key: Key, Data: Data, Contacts: Contacts}
 }
 
 func NewRPC(Type RPCType, IsResponse bool, ID *KademliaID, Payload *Payload, Source *Contact, Destination *Contact) *RPC {
-	return &RPC{Type: Type, IsResponse: IsResponse, ID: ID, Payload: Payload, Source: Source, Destination: Destination}
+	return &RPC{Header: NewHeader(), Type: Type, IsResponse: IsResponse, ID: ID, Payload: Payload, Source: Source, Destination: Destination}
+}
+
+// ValidateHeader returns an error if the RPC was sent by a node speaking
+// another protocol version, belonging to another network or using another ID length
+func ValidateHeader(rpc *RPC) error {
+	if rpc.Header == nil {
+		return fmt.Errorf("missing header")
+	}
+	// Older versions do not send all fields, so the version is checked first
+	if rpc.Header.Version != ProtocolVersion {
+		return fmt.Errorf("protocol version mismatch: got %d, want %d", rpc.Header.Version, ProtocolVersion)
+	}
+	if rpc.Header.IDLength != IDLength {
+		return fmt.Errorf("ID length mismatch: got %d, want %d", rpc.Header.IDLength, IDLength)
+	}
+	if rpc.Header.NetworkID != NetworkID {
+		return fmt.Errorf("network ID mismatch: got %q, want %q", rpc.Header.NetworkID, NetworkID)
+	}
+	return nil
 }
 
 func ValidateRPC(rpc *RPC) bool {
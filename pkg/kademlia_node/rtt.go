@@ -0,0 +1,148 @@
+package kademlia_node
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	MinTimeout    = 200 * time.Millisecond // Lower bound of the adaptive timeout
+	MaxTimeout    = 10 * time.Second       // Upper bound of the adaptive timeout
+	Retries       = 1                      // Number of times a request is resent after a timeout, so that one lost packet does not fail a contact
+	BackoffFactor = 2                      // Factor the timeout grows with on every retry
+)
+
+// RTTStats holds the round trip time statistics of a contact,
+// estimated as described in RFC 6298
+type RTTStats struct {
+	SmoothedRTT time.Duration
+	RTTVariance time.Duration
+	LastRTT     time.Duration
+	Samples     int
+	Timeouts    int
+	Backoff     int // Timeouts since the last sample, each one grows the timeout by the BackoffFactor
+}
+
+// Update adds a round trip time sample to the statistics
+func (stats *RTTStats) Update(rtt time.Duration) {
+	if stats.Samples == 0 {
+		stats.SmoothedRTT = rtt
+		stats.RTTVariance = rtt / 2
+	} else {
+		diff := stats.SmoothedRTT - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		stats.RTTVariance = (3*stats.RTTVariance + diff) / 4
+		stats.SmoothedRTT = (7*stats.SmoothedRTT + rtt) / 8
+	}
+	stats.LastRTT = rtt
+	stats.Samples++
+	stats.Backoff = 0
+}
+
+// RecordTimeout backs off the timeout after a request timed out,
+// until the next sample is taken as described in RFC 6298
+func (stats *RTTStats) RecordTimeout() {
+	stats.Timeouts++
+	stats.Backoff++
+}
+
+// Timeout returns the time to wait for a response, the default Timeout
+// is used until the first sample has been taken. The timeout grows by the
+// BackoffFactor for every timeout since the last sample
+func (stats *RTTStats) Timeout() time.Duration {
+	timeout := Timeout
+	if stats.Samples > 0 {
+		timeout = stats.SmoothedRTT + 4*stats.RTTVariance
+		if timeout < MinTimeout {
+			timeout = MinTimeout
+		}
+	}
+	for i := 0; i < stats.Backoff && timeout < MaxTimeout; i++ {
+		timeout *= time.Duration(BackoffFactor)
+	}
+	if timeout > MaxTimeout {
+		return MaxTimeout
+	}
+	return timeout
+}
+
+// RetryTimeout returns the timeout for the given attempt using exponential backoff
+func RetryTimeout(timeout time.Duration, attempt int) time.Duration {
+	for i := 0; i < attempt; i++ {
+		timeout *= time.Duration(BackoffFactor)
+		if timeout >= MaxTimeout {
+			return MaxTimeout
+		}
+	}
+	return timeout
+}
+
+// RTTEstimator keeps the round trip time statistics of all contacts
+type RTTEstimator struct {
+	Stats map[KademliaID]*RTTStats
+	Mutex sync.RWMutex
+}
+
+// NewRTTEstimator returns a new instance of a RTTEstimator
+func NewRTTEstimator() *RTTEstimator {
+	return &RTTEstimator{Stats: make(map[KademliaID]*RTTStats)}
+}
+
+// Timeout returns the time to wait for a response from the contact
+func (estimator *RTTEstimator) Timeout(id *KademliaID) time.Duration {
+	estimator.Mutex.RLock()
+	defer estimator.Mutex.RUnlock()
+	if stats, exists := estimator.Stats[*id]; exists {
+		return stats.Timeout()
+	}
+	return Timeout
+}
+
+// Update adds a round trip time sample for the contact
+func (estimator *RTTEstimator) Update(id *KademliaID, rtt time.Duration) {
+	estimator.Mutex.Lock()
+	defer estimator.Mutex.Unlock()
+	estimator.get(id).Update(rtt)
+}
+
+// RecordTimeout counts a request to the contact that timed out and backs off its timeout
+func (estimator *RTTEstimator) RecordTimeout(id *KademliaID) {
+	estimator.Mutex.Lock()
+	defer estimator.Mutex.Unlock()
+	estimator.get(id).RecordTimeout()
+}
+
+// GetStats returns a copy of the statistics of the contact
+func (estimator *RTTEstimator) GetStats(id *KademliaID) (RTTStats, bool) {
+	estimator.Mutex.RLock()
+	defer estimator.Mutex.RUnlock()
+	stats, exists := estimator.Stats[*id]
+	if !exists {
+		return RTTStats{}, false
+	}
+	return *stats, true
+}
+
+// Snapshot returns a copy of the statistics of all contacts keyed by contact ID
+func (estimator *RTTEstimator) Snapshot() map[string]RTTStats {
+	estimator.Mutex.RLock()
+	defer estimator.Mutex.RUnlock()
+	snapshot := make(map[string]RTTStats, len(estimator.Stats))
+	for id, stats := range estimator.Stats {
+		snapshot[id.String()] = *stats
+	}
+	return snapshot
+}
+
+// get returns the statistics of the contact, creating them if needed.
+// The caller must hold the write lock
+func (estimator *RTTEstimator) get(id *KademliaID) *RTTStats {
+	stats, exists := estimator.Stats[*id]
+	if !exists {
+		stats = &RTTStats{}
+		estimator.Stats[*id] = stats
+	}
+	return stats
+}
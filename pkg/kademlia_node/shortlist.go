@@ -4,6 +4,7 @@ import (
 	"container/list"
 	"sort"
 	"strings"
+	"time"
 )
 
 // shortlist represents a list of contacts sorted by distance to a target ID
@@ -77,6 +78,29 @@ func (shortlist *shortlist) GetClosestContactsNotContacted(k int, contacted map[
 	return contacts
 }
 
+// GetFastestContactsNotContacted returns k contacts not contacted like GetClosestContactsNotContacted,
+// but among contacts sharing as many leading bits with the target, the ones with a lower round
+// trip time come first. Contacts without a known round trip time come last among them
+func (shortlist *shortlist) GetFastestContactsNotContacted(k int, contacted map[*KademliaID]bool, rtts map[KademliaID]time.Duration) []*Contact {
+	contacts := shortlist.GetClosestContactsNotContacted(shortlist.Len(), contacted)
+	sort.SliceStable(contacts, func(i, j int) bool {
+		bucketI, bucketJ := GetBucketIndex(contacts[i].Id, shortlist.Target), GetBucketIndex(contacts[j].Id, shortlist.Target)
+		if bucketI != bucketJ {
+			return bucketI < bucketJ
+		}
+		rttI, knownI := rtts[*contacts[i].Id]
+		rttJ, knownJ := rtts[*contacts[j].Id]
+		if knownI != knownJ {
+			return knownI
+		}
+		return knownI && rttI < rttJ
+	})
+	if len(contacts) > k {
+		contacts = contacts[:k]
+	}
+	return contacts
+}
+
 // GetClosestContacts returns the closest contacts from the shortlist
 func (shortlist *shortlist) GetClosestContacts(k int) []*Contact {
 	var contacts []*Contact
@@ -0,0 +1,186 @@
+package kademlia_node
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SnapshotInterval is how often the routing table is saved
+var SnapshotInterval = 5 * time.Minute
+
+// RoutingTableSnapshot is the routing table of a node as saved to disk
+type RoutingTableSnapshot struct {
+	Id       *KademliaID
+	SavedAt  time.Time
+	Contacts []SnapshotContact
+}
+
+// SnapshotContact is a contact as saved to disk, including the liveness
+// of the contact that is never sent to other nodes
+type SnapshotContact struct {
+	Contact
+	Failures    int
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	LastRTT     time.Duration
+	SmoothedRTT time.Duration
+}
+
+// NewSnapshotContact returns the record of the contact saved to disk
+func NewSnapshotContact(contact Contact) SnapshotContact {
+	contact.Distance = nil
+	return SnapshotContact{
+		Contact:     contact,
+		Failures:    contact.Failures,
+		FirstSeen:   contact.FirstSeen,
+		LastSeen:    contact.LastSeen,
+		LastRTT:     contact.LastRTT,
+		SmoothedRTT: contact.SmoothedRTT,
+	}
+}
+
+// ToContact returns the saved contact together with its liveness
+func (record SnapshotContact) ToContact() Contact {
+	contact := record.Contact
+	contact.Failures = record.Failures
+	contact.FirstSeen = record.FirstSeen
+	contact.LastSeen = record.LastSeen
+	contact.LastRTT = record.LastRTT
+	contact.SmoothedRTT = record.SmoothedRTT
+	return contact
+}
+
+// NewRoutingTableSnapshot returns a snapshot of the contacts in the routing table of the node
+func NewRoutingTableSnapshot(node *Node) *RoutingTableSnapshot {
+	snapshot := &RoutingTableSnapshot{Id: node.GetMe().Id, SavedAt: time.Now()}
+	node.RoutingTable.ForEachContact(func(contact Contact) bool {
+		snapshot.Contacts = append(snapshot.Contacts, NewSnapshotContact(contact))
+		return true
+	})
+	return snapshot
+}
+
+// Save writes the snapshot to the file. The file is replaced atomically,
+// so a crash while saving leaves the previous snapshot intact
+func (snapshot *RoutingTableSnapshot) Save(path string) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadRoutingTableSnapshot reads a snapshot from the file
+func LoadRoutingTableSnapshot(path string) (*RoutingTableSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot RoutingTableSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %v", path, err)
+	}
+	if snapshot.Id == nil {
+		return nil, fmt.Errorf("invalid snapshot %s: missing node ID", path)
+	}
+	return &snapshot, nil
+}
+
+// SaveRoutingTable writes a snapshot of the routing table to the file
+func (node *Node) SaveRoutingTable(path string) error {
+	return NewRoutingTableSnapshot(node).Save(path)
+}
+
+// RestoreRoutingTable adds the valid contacts of the snapshot to the routing table
+// and returns them. The contacts have not been verified and should be passed
+// to VerifyContacts
+func (node *Node) RestoreRoutingTable(snapshot *RoutingTableSnapshot) []*Contact {
+	var restored []*Contact
+	for _, record := range snapshot.Contacts {
+		contact := record.ToContact()
+		if !ValidateContact(&contact) || contact.Id.Equals(node.GetMe().Id) || !node.CanReach(&contact) {
+			continue
+		}
+		node.RoutingTable.RestoreContact(&contact)
+		restored = append(restored, &contact)
+	}
+	return restored
+}
+
+// RestoreContact adds a contact saved in a snapshot and keeps its liveness.
+// Contacts already in the RoutingTable are left as they are and contacts of
+// full buckets are queued as replacements without pinging the bucket
+func (routingTable *RoutingTable) RestoreContact(contact *Contact) {
+	routingTable.Mutex.Lock()
+	defer routingTable.Mutex.Unlock()
+
+	restored := *contact
+	restored.CalcDistance(routingTable.Node.GetMe().Id)
+	if restored.Id.Equals(routingTable.Node.GetMe().Id) {
+		return
+	}
+	bucket := routingTable.bucketFor(restored.Id)
+	if bucket.Contains(restored) || !routingTable.isDiverse(bucket, &restored) {
+		return
+	}
+	for bucket.Len() >= routingTable.Node.K && routingTable.canSplit(&restored) {
+		routingTable.Root.Leaf(restored.Id).Split(routingTable.isDiverse)
+		bucket = routingTable.bucketFor(restored.Id)
+	}
+	if bucket.Len() >= routingTable.Node.K {
+		bucket.AddReplacement(restored)
+		return
+	}
+	bucket.RemoveReplacement(restored)
+	bucket.AddContact(restored)
+}
+
+// VerifyContacts pings the contacts, Alpha at a time, and removes
+// the ones that do not respond from the routing table
+func (node *Node) VerifyContacts(contacts []*Contact) {
+	alpha := node.Alpha
+	if alpha < 1 {
+		alpha = 1
+	}
+	semaphore := make(chan struct{}, alpha)
+	var wg sync.WaitGroup
+	for _, contact := range contacts {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(c *Contact) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			if _, err := node.MessageHandler.SendPingRequest(node.GetMe(), c); err != nil {
+				node.RoutingTable.RemoveContact(c)
+			}
+		}(contact)
+	}
+	wg.Wait()
+}
+
+// SnapshotLoop saves the routing table to the file every SnapshotInterval
+func (node *Node) SnapshotLoop(path string) {
+	ticker := time.NewTicker(SnapshotInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := node.SaveRoutingTable(path); err != nil {
+			fmt.Println("Error saving routing table:", err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package kademlia_node
+
+var (
+	// BucketSplitting makes new routing tables start with a single bucket that
+	// is split when it is full and covers our own ID, instead of allocating
+	// IDLength*8 fixed buckets
+	BucketSplitting = false
+	// RelaxedSplitting also splits full buckets not covering our own ID as long
+	// as the new contact is among the K closest contacts to our own ID, which
+	// keeps deeper unbalanced subtrees around our own ID
+	RelaxedSplitting = false
+)
+
+// treeNode is a node of the binary tree of a bucket splitting RoutingTable.
+// Leaves hold a bucket with the contacts whose IDs start with the first
+// Depth bits of Prefix
+type treeNode struct {
+	Prefix   KademliaID
+	Depth    int
+	Bucket   *bucket
+	Children [2]*treeNode
+}
+
+// newTreeNode returns a new leaf covering the IDs starting with the first depth bits of prefix
+func newTreeNode(prefix KademliaID, depth int, k int) *treeNode {
+	return &treeNode{Prefix: prefix, Depth: depth, Bucket: NewBucket(k)}
+}
+
+// bit returns the bit of the KademliaID at the given position, counted from the most significant bit
+func bit(id *KademliaID, position int) int {
+	return int(id[position/8]>>(7-position%8)) & 1
+}
+
+// IsLeaf returns true if the node holds a bucket
+func (node *treeNode) IsLeaf() bool {
+	return node.Bucket != nil
+}
+
+// Covers returns true if the KademliaID starts with the prefix of the node
+func (node *treeNode) Covers(id *KademliaID) bool {
+	for i := 0; i < node.Depth; i++ {
+		if bit(id, i) != bit(&node.Prefix, i) {
+			return false
+		}
+	}
+	return true
+}
+
+// Leaf returns the leaf covering the KademliaID
+func (node *treeNode) Leaf(id *KademliaID) *treeNode {
+	for !node.IsLeaf() {
+		node = node.Children[bit(id, node.Depth)]
+	}
+	return node
+}
+
+// Leaves returns all leaves below the node from left to right
+func (node *treeNode) Leaves() []*treeNode {
+	if node.IsLeaf() {
+		return []*treeNode{node}
+	}
+	return append(node.Children[0].Leaves(), node.Children[1].Leaves()...)
+}
+
+// Split turns the leaf into an inner node with two leaves and distributes the
+// contacts and replacements between them by the next bit of their IDs.
+// Leaves with room take the candidates they allow, see PromoteReplacement
+func (node *treeNode) Split(allowed func(bucket *bucket, contact *Contact) bool) {
+	for i := range node.Children {
+		prefix := node.Prefix
+		mask := byte(0x80 >> (node.Depth % 8))
+		if i == 1 {
+			prefix[node.Depth/8] |= mask
+		} else {
+			prefix[node.Depth/8] &^= mask
+		}
+		child := newTreeNode(prefix, node.Depth+1, node.Bucket.K)
+		child.Bucket.LastActivity = node.Bucket.LastActivity
+		node.Children[i] = child
+	}
+	// Walk from the back so that the most recently seen contacts stay in front
+	for elt := node.Bucket.List.Back(); elt != nil; elt = elt.Prev() {
+		contact := elt.Value.(Contact)
+		node.Children[bit(contact.Id, node.Depth)].Bucket.List.PushFront(contact)
+	}
+	for elt := node.Bucket.Replacements.Back(); elt != nil; elt = elt.Prev() {
+		contact := elt.Value.(Contact)
+		node.Children[bit(contact.Id, node.Depth)].Bucket.Replacements.PushFront(contact)
+	}
+	node.Bucket = nil
+	// A side that got fewer contacts can take its candidates right away
+	for _, child := range node.Children {
+		for {
+			if _, promoted := child.Bucket.PromoteReplacement(allowed); !promoted {
+				break
+			}
+		}
+	}
+}
+
+// prefixString returns the first length bits of the KademliaID as a string of 0s and 1s
+func prefixString(id *KademliaID, length int) string {
+	prefix := make([]byte, length)
+	for i := range prefix {
+		prefix[i] = "01"[bit(id, i)]
+	}
+	return string(prefix)
+}
+
+// PrefixString returns the bits of the prefix of the node as a string of 0s and 1s
+func (node *treeNode) PrefixString() string {
+	return prefixString(&node.Prefix, node.Depth)
+}
+
+// RandomID returns a random KademliaID covered by the node
+func (node *treeNode) RandomID() *KademliaID {
+	return randomIDWithPrefix(&node.Prefix, node.Depth)
+}
+
+// randomIDWithPrefix returns a random KademliaID starting with the first length bits of prefix
+func randomIDWithPrefix(prefix *KademliaID, length int) *KademliaID {
+	id := NewRandomKademliaID()
+	for i := 0; i < length; i++ {
+		mask := byte(0x80 >> (i % 8))
+		id[i/8] = id[i/8]&^mask | prefix[i/8]&mask
+	}
+	return id
+}
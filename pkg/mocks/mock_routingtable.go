@@ -0,0 +1,115 @@
+package mocks
+
+import (
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"sync"
+	"time"
+)
+
+// MockRoutingTable records the contacts added to and removed from it and
+// returns its contacts in insertion order as the closest contacts
+type MockRoutingTable struct {
+	Contacts  []*kademlia.Contact
+	Removed   []*kademlia.Contact
+	Failed    []*kademlia.Contact
+	Succeeded []*kademlia.Contact
+	Mutex     sync.Mutex
+}
+
+func NewMockRoutingTable() *MockRoutingTable {
+	return &MockRoutingTable{}
+}
+
+func (table *MockRoutingTable) AddContact(contact *kademlia.Contact) {
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+	table.Contacts = append(table.Contacts, contact)
+}
+
+func (table *MockRoutingTable) RestoreContact(contact *kademlia.Contact) {
+	table.AddContact(contact)
+}
+
+func (table *MockRoutingTable) RemoveContact(contact *kademlia.Contact) {
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+	table.Removed = append(table.Removed, contact)
+}
+
+func (table *MockRoutingTable) RecordFailure(contact *kademlia.Contact) {
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+	table.Failed = append(table.Failed, contact)
+}
+
+func (table *MockRoutingTable) RecordSuccess(contact *kademlia.Contact, rtt time.Duration) {
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+	table.Succeeded = append(table.Succeeded, contact)
+}
+
+func (table *MockRoutingTable) FindClosestContacts(target *kademlia.KademliaID) []*kademlia.Contact {
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+	return append([]*kademlia.Contact(nil), table.Contacts...)
+}
+
+func (table *MockRoutingTable) FindClosestContactsFiltered(target *kademlia.KademliaID, count int, filter kademlia.ContactFilter) []*kademlia.Contact {
+	var contacts []*kademlia.Contact
+	for _, contact := range table.FindClosestContacts(target) {
+		if filter == nil || filter(*contact) {
+			contacts = append(contacts, contact)
+		}
+	}
+	if count > 0 && count < len(contacts) {
+		contacts = contacts[:count]
+	}
+	return contacts
+}
+
+func (table *MockRoutingTable) UpdateRoutingTable(contacts []*kademlia.Contact) {
+	for _, contact := range contacts {
+		table.AddContact(contact)
+	}
+}
+
+func (table *MockRoutingTable) Touch(id *kademlia.KademliaID) {
+	// Do nothing
+}
+
+func (table *MockRoutingTable) GetRefreshTargets(since time.Time) []*kademlia.KademliaID {
+	return nil
+}
+
+func (table *MockRoutingTable) GetBucketStats() []kademlia.BucketStats {
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+	return []kademlia.BucketStats{{Contacts: len(table.Contacts)}}
+}
+
+func (table *MockRoutingTable) ForEachContact(fn func(contact kademlia.Contact) bool) {
+	for _, contact := range table.FindClosestContacts(nil) {
+		if !fn(*contact) {
+			return
+		}
+	}
+}
+
+func (table *MockRoutingTable) GetContactStats() []kademlia.ContactStats {
+	var stats []kademlia.ContactStats
+	table.ForEachContact(func(contact kademlia.Contact) bool {
+		stats = append(stats, kademlia.ContactStats{Id: contact.Id.String(), Address: contact.Address()})
+		return true
+	})
+	return stats
+}
+
+func (table *MockRoutingTable) GetBucketCounts() []int {
+	return []int{table.Size()}
+}
+
+func (table *MockRoutingTable) Size() int {
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+	return len(table.Contacts)
+}
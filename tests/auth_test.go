@@ -0,0 +1,70 @@
+package tests
+
+import (
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"testing"
+)
+
+func TestSignMessageWithoutKey(t *testing.T) {
+	kademlia.ClusterKey = nil
+
+	data := []byte("test message")
+	signed := kademlia.SignMessage(data)
+	if string(signed) != string(data) {
+		t.Errorf("Expected message to be unchanged without a cluster key, got %s", signed)
+	}
+
+	verified, err := kademlia.VerifyMessage(signed)
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if string(verified) != string(data) {
+		t.Errorf("Expected message %s, got %s", data, verified)
+	}
+}
+
+func TestSignAndVerifyMessage(t *testing.T) {
+	kademlia.ClusterKey = []byte("secret")
+	defer func() { kademlia.ClusterKey = nil }()
+
+	data := []byte("test message")
+	signed := kademlia.SignMessage(data)
+	if len(signed) != len(data)+kademlia.MACLength {
+		t.Fatalf("Expected signed message of length %d, got %d", len(data)+kademlia.MACLength, len(signed))
+	}
+
+	verified, err := kademlia.VerifyMessage(signed)
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if string(verified) != string(data) {
+		t.Errorf("Expected message %s, got %s", data, verified)
+	}
+}
+
+func TestVerifyTamperedMessage(t *testing.T) {
+	kademlia.ClusterKey = []byte("secret")
+	defer func() { kademlia.ClusterKey = nil }()
+
+	signed := kademlia.SignMessage([]byte("test message"))
+	signed[0] ^= 0xFF
+	if _, err := kademlia.VerifyMessage(signed); err == nil {
+		t.Errorf("Expected tampered message to be rejected")
+	}
+
+	if _, err := kademlia.VerifyMessage([]byte("short")); err == nil {
+		t.Errorf("Expected message without MAC to be rejected")
+	}
+}
+
+func TestVerifyMessageWrongKey(t *testing.T) {
+	kademlia.ClusterKey = []byte("secret")
+	signed := kademlia.SignMessage([]byte("test message"))
+
+	kademlia.ClusterKey = []byte("other secret")
+	defer func() { kademlia.ClusterKey = nil }()
+
+	if _, err := kademlia.VerifyMessage(signed); err == nil {
+		t.Errorf("Expected message signed with another key to be rejected")
+	}
+}
@@ -123,3 +123,60 @@ func TestGetLeastRecentlySeenContact(t *testing.T) {
 		t.Errorf("Expected least recently seen contact ID to be %s, got %s", contact1.Id.String(), leastRecentlySeen.Id.String())
 	}
 }
+
+func TestAddReplacement(t *testing.T) {
+	b := kademlia.NewBucket(20)
+	for i := 0; i < kademlia.ReplacementCacheSize+1; i++ {
+		contact := kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", i))}
+		b.AddReplacement(contact)
+	}
+
+	replacements := b.GetReplacements()
+	if len(replacements) != kademlia.ReplacementCacheSize {
+		t.Fatalf("Expected %d replacements, got %d", kademlia.ReplacementCacheSize, len(replacements))
+	}
+	// The oldest candidate is dropped and the newest is first
+	expected := kademlia.NewKademliaID(fmt.Sprintf("%040d", kademlia.ReplacementCacheSize))
+	if !replacements[0].Id.Equals(expected) {
+		t.Errorf("Expected newest replacement to be %s, got %s", expected, replacements[0].Id)
+	}
+	oldest := kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", 0))}
+	for _, replacement := range replacements {
+		if replacement.Id.Equals(oldest.Id) {
+			t.Errorf("Expected oldest replacement %s to be dropped", oldest.Id)
+		}
+	}
+
+	// Seeing a candidate again moves it to the front
+	b.AddReplacement(replacements[len(replacements)-1])
+	if !b.GetReplacements()[0].Id.Equals(replacements[len(replacements)-1].Id) {
+		t.Errorf("Expected re-added replacement to be first")
+	}
+	if len(b.GetReplacements()) != kademlia.ReplacementCacheSize {
+		t.Errorf("Expected re-adding a replacement to not grow the cache")
+	}
+}
+
+func TestPromoteReplacement(t *testing.T) {
+	b := kademlia.NewBucket(1)
+	contact := kademlia.Contact{Id: kademlia.NewKademliaID("0000000000000000000000000000000000000001")}
+	replacement := kademlia.Contact{Id: kademlia.NewKademliaID("0000000000000000000000000000000000000002")}
+	b.AddContact(contact)
+	b.AddReplacement(replacement)
+
+	if _, promoted := b.PromoteReplacement(nil); promoted {
+		t.Errorf("Expected no promotion into a full bucket")
+	}
+
+	b.RemoveContact(contact)
+	promotedContact, promoted := b.PromoteReplacement(nil)
+	if !promoted || !promotedContact.Id.Equals(replacement.Id) {
+		t.Fatalf("Expected replacement %s to be promoted", replacement.Id)
+	}
+	if !b.Contains(replacement) {
+		t.Errorf("Expected promoted replacement to be in the bucket")
+	}
+	if len(b.GetReplacements()) != 0 {
+		t.Errorf("Expected replacement cache to be empty after promotion")
+	}
+}
@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"bytes"
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"testing"
+)
+
+func TestCompressMessage(t *testing.T) {
+	data := bytes.Repeat([]byte("kademlia "), 500)
+
+	compressed := kademlia.CompressMessage(data)
+	if !kademlia.IsCompressedMessage(compressed) {
+		t.Fatalf("Expected message to be compressed")
+	}
+	if len(compressed) >= len(data) {
+		t.Errorf("Expected compressed message to be smaller than %d bytes, got %d", len(data), len(compressed))
+	}
+
+	decompressed, err := kademlia.DecompressMessage(compressed)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Errorf("Expected decompressed message to equal the original")
+	}
+}
+
+func TestCompressMessageIncompressible(t *testing.T) {
+	data := []byte(`{"ID":"1"}`)
+	if compressed := kademlia.CompressMessage(data); !bytes.Equal(compressed, data) {
+		t.Errorf("Expected message that does not shrink to be unchanged, got %v", compressed)
+	}
+	if kademlia.IsCompressedMessage(data) {
+		t.Errorf("Expected JSON message to not be compressed")
+	}
+	if _, err := kademlia.DecompressMessage(data); err == nil {
+		t.Errorf("Expected error when decompressing an uncompressed message")
+	}
+}
+
+func TestDecompressMessageBomb(t *testing.T) {
+	bomb := kademlia.CompressMessage(make([]byte, kademlia.MaxDecompressedSize+1))
+	if _, err := kademlia.DecompressMessage(bomb); err == nil {
+		t.Errorf("Expected message expanding beyond %d bytes to be rejected", kademlia.MaxDecompressedSize)
+	}
+}
+
+func TestCompressedMessageBetweenNodes(t *testing.T) {
+	kademlia.Compression = true
+	defer func() { kademlia.Compression = false }()
+
+	sender := initNode()
+	recipient := kademlia.NewContact(kademlia.NewRandomKademliaID(), "127.0.0.1", 8000)
+	data := bytes.Repeat([]byte("data "), 1000)
+	rpc := kademlia.NewRPC(kademlia.StoreRequest, false, kademlia.NewRandomKademliaID(), kademlia.NewPayload(nil, data, nil), sender.Me, recipient)
+
+	// Contacts that do not advertise compression get uncompressed messages
+	serialized, err := sender.MessageHandler.SerializeMessage(rpc)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if kademlia.IsCompressedMessage(serialized) {
+		t.Errorf("Expected message to contact without compression support to be uncompressed")
+	}
+
+	recipient.Compression = true
+	serialized, err = sender.MessageHandler.SerializeMessage(rpc)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !kademlia.IsCompressedMessage(serialized) {
+		t.Fatalf("Expected message to be compressed")
+	}
+
+	received, err := initNode().MessageHandler.DeserializeMessage(serialized)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.Equal(received.Payload.Data, data) {
+		t.Errorf("Expected payload data to survive compression")
+	}
+}
@@ -2,6 +2,7 @@ package tests
 
 import (
 	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"net"
 	"testing"
 )
 
@@ -198,3 +199,79 @@ func TestContactCandidates_Less(t *testing.T) {
 		t.Errorf("Expected contact at index 0 to be less than contact at index 1")
 	}
 }
+
+func TestValidateContact(t *testing.T) {
+	id := kademlia.NewKademliaID("0000000000000000000000000000000000000001")
+
+	if !kademlia.ValidateContact(kademlia.NewContact(id, "127.0.0.1", 8080)) {
+		t.Errorf("Expected contact to be valid")
+	}
+	if kademlia.ValidateContact(nil) {
+		t.Errorf("Expected nil contact to be invalid")
+	}
+	if kademlia.ValidateContact(kademlia.NewContact(nil, "127.0.0.1", 8080)) {
+		t.Errorf("Expected contact without ID to be invalid")
+	}
+	if kademlia.ValidateContact(kademlia.NewContact(id, "", 8080)) {
+		t.Errorf("Expected contact without IP to be invalid")
+	}
+	if kademlia.ValidateContact(kademlia.NewContact(id, "127.0.0.1", 0)) {
+		t.Errorf("Expected contact without port to be invalid")
+	}
+	if kademlia.ValidateContact(kademlia.NewContact(id, "127.0.0.1", 70000)) {
+		t.Errorf("Expected contact with port out of range to be invalid")
+	}
+}
+
+func TestContactAddress(t *testing.T) {
+	id := kademlia.NewKademliaID("0000000000000000000000000000000000000001")
+
+	ipv4 := kademlia.NewContact(id, "127.0.0.1", 8080)
+	if ipv4.Address() != "127.0.0.1:8080" {
+		t.Errorf("Expected address 127.0.0.1:8080, got %s", ipv4.Address())
+	}
+
+	ipv6 := kademlia.NewContact(id, "fd00::2", 8080)
+	if ipv6.Address() != "[fd00::2]:8080" {
+		t.Errorf("Expected address [fd00::2]:8080, got %s", ipv6.Address())
+	}
+	addr, err := net.ResolveUDPAddr("udp", ipv6.Address())
+	if err != nil {
+		t.Fatalf("Expected IPv6 address to resolve, got %v", err)
+	}
+	if addr.Port != 8080 || !addr.IP.Equal(net.ParseIP("fd00::2")) {
+		t.Errorf("Expected [fd00::2]:8080, got %s", addr)
+	}
+}
+
+func TestContactIsIPv6(t *testing.T) {
+	id := kademlia.NewKademliaID("0000000000000000000000000000000000000001")
+
+	if kademlia.NewContact(id, "127.0.0.1", 8080).IsIPv6() {
+		t.Errorf("Expected IPv4 contact to not be IPv6")
+	}
+	if !kademlia.NewContact(id, "::1", 8080).IsIPv6() {
+		t.Errorf("Expected IPv6 contact to be IPv6")
+	}
+	if kademlia.NewContact(id, "bootstrap-node", 8080).IsIPv6() {
+		t.Errorf("Expected contact with host name to not be IPv6")
+	}
+}
+
+func TestContactSubnet(t *testing.T) {
+	id := kademlia.NewKademliaID("0000000000000000000000000000000000000001")
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"10.1.1.17", "10.1.1.0/24"},
+		{"::ffff:10.1.1.17", "10.1.1.0/24"},
+		{"fd00:1:2:3:4:5:6:7", "fd00:1:2:3::/64"},
+		{"bootstrap-node", ""},
+	}
+	for _, test := range tests {
+		if subnet := kademlia.NewContact(id, test.ip, 8080).Subnet(); subnet != test.expected {
+			t.Errorf("Subnet(%s) = %q; want %q", test.ip, subnet, test.expected)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package tests
+
+import (
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"sync"
+	"testing"
+)
+
+func TestCountersIncrement(t *testing.T) {
+	counters := kademlia.NewCounters()
+
+	if counters.Get("test") != 0 {
+		t.Errorf("Expected unknown counter to be 0, got %d", counters.Get("test"))
+	}
+
+	counters.Increment("test")
+	counters.Add("test", 2)
+	if counters.Get("test") != 3 {
+		t.Errorf("Expected counter to be 3, got %d", counters.Get("test"))
+	}
+}
+
+func TestCountersConcurrent(t *testing.T) {
+	counters := kademlia.NewCounters()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counters.Increment("test")
+		}()
+	}
+	wg.Wait()
+
+	if counters.Get("test") != 100 {
+		t.Errorf("Expected counter to be 100, got %d", counters.Get("test"))
+	}
+}
+
+func TestCountersSnapshot(t *testing.T) {
+	counters := kademlia.NewCounters()
+	counters.Increment("a")
+	counters.Increment("b")
+
+	snapshot := counters.Snapshot()
+	if len(snapshot) != 2 || snapshot["a"] != 1 || snapshot["b"] != 1 {
+		t.Errorf("Expected snapshot with a=1 and b=1, got %v", snapshot)
+	}
+
+	// The snapshot is a copy
+	snapshot["a"] = 10
+	if counters.Get("a") != 1 {
+		t.Errorf("Expected counter to be unaffected by snapshot changes, got %d", counters.Get("a"))
+	}
+}
@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"testing"
+)
+
+func initEncryptedNode(t *testing.T) *kademlia.Node {
+	node := initNode()
+	privateKey, err := kademlia.NewKeyPair()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	node.PrivateKey = privateKey
+	node.Me.PublicKey = privateKey.PublicKey().Bytes()
+	return node
+}
+
+func TestSealAndOpenMessage(t *testing.T) {
+	sender, _ := kademlia.NewKeyPair()
+	recipient, _ := kademlia.NewKeyPair()
+
+	plaintext := []byte("test message")
+	sealed, err := kademlia.SealMessage(sender, recipient.PublicKey().Bytes(), plaintext)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(sealed.Ciphertext) == string(plaintext) {
+		t.Errorf("Expected ciphertext to differ from plaintext")
+	}
+
+	opened, err := kademlia.OpenMessage(recipient, sealed)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(opened) != string(plaintext) {
+		t.Errorf("Expected message %s, got %s", plaintext, opened)
+	}
+}
+
+func TestOpenMessageWrongRecipient(t *testing.T) {
+	sender, _ := kademlia.NewKeyPair()
+	recipient, _ := kademlia.NewKeyPair()
+	other, _ := kademlia.NewKeyPair()
+
+	sealed, _ := kademlia.SealMessage(sender, recipient.PublicKey().Bytes(), []byte("test message"))
+	if _, err := kademlia.OpenMessage(other, sealed); err == nil {
+		t.Errorf("Expected message sealed for another node to be rejected")
+	}
+
+	sealed.Ciphertext[0] ^= 0xFF
+	if _, err := kademlia.OpenMessage(recipient, sealed); err == nil {
+		t.Errorf("Expected tampered message to be rejected")
+	}
+}
+
+func TestSealMessageInvalidKey(t *testing.T) {
+	sender, _ := kademlia.NewKeyPair()
+	if _, err := kademlia.SealMessage(sender, []byte("invalid key"), []byte("test message")); err == nil {
+		t.Errorf("Expected invalid recipient key to be rejected")
+	}
+}
+
+func TestEncryptedMessageBetweenNodes(t *testing.T) {
+	sender := initEncryptedNode(t)
+	recipient := initEncryptedNode(t)
+	recipient.Me.Id = kademlia.NewRandomKademliaID()
+
+	rpc := kademlia.NewRPC(kademlia.StoreRequest, false, kademlia.NewRandomKademliaID(), kademlia.NewPayload(nil, []byte("secret data"), nil), sender.Me, recipient.Me)
+	data, err := sender.MessageHandler.SerializeMessage(rpc)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var sealed kademlia.SealedMessage
+	if err := json.Unmarshal(data, &sealed); err != nil || len(sealed.Ciphertext) == 0 {
+		t.Fatalf("Expected an encrypted message, got %s", data)
+	}
+
+	received, err := recipient.MessageHandler.DeserializeMessage(data)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(received.Payload.Data) != "secret data" {
+		t.Errorf("Expected data %s, got %s", "secret data", received.Payload.Data)
+	}
+
+	// Nodes without a key pair can not read the message
+	plainNode := initNode()
+	if _, err := plainNode.MessageHandler.DeserializeMessage(data); err == nil {
+		t.Errorf("Expected encrypted message to be rejected without a key pair")
+	}
+}
+
+func TestUnencryptedMessageToContactWithoutKey(t *testing.T) {
+	sender := initEncryptedNode(t)
+	destination := kademlia.NewContact(kademlia.NewRandomKademliaID(), "127.0.0.1", 8000)
+
+	rpc := kademlia.NewRPC(kademlia.PingRequest, false, kademlia.NewRandomKademliaID(), nil, sender.Me, destination)
+	data, err := sender.MessageHandler.SerializeMessage(rpc)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	received, err := initNode().MessageHandler.DeserializeMessage(data)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !received.ID.Equals(rpc.ID) {
+		t.Errorf("Expected ID %s, got %s", rpc.ID, received.ID)
+	}
+	if string(received.Source.PublicKey) != string(sender.Me.PublicKey) {
+		t.Errorf("Expected the source to advertise its public key")
+	}
+}
+
+func TestEncryptedNodeRefusesPlaintext(t *testing.T) {
+	node := initEncryptedNode(t)
+	plainNode := initNode()
+	plainNode.Me.Id = kademlia.NewRandomKademliaID()
+
+	// Requests other than pings are not sent to contacts without a public key
+	store := kademlia.NewRPC(kademlia.StoreRequest, false, kademlia.NewRandomKademliaID(), kademlia.NewPayload(nil, []byte("data"), nil), node.Me, plainNode.Me)
+	if _, err := node.MessageHandler.SerializeMessage(store); err == nil {
+		t.Errorf("Expected plaintext request to be refused")
+	}
+
+	// and are not accepted in plaintext
+	store = kademlia.NewRPC(kademlia.StoreRequest, false, kademlia.NewRandomKademliaID(), kademlia.NewPayload(nil, []byte("data"), nil), plainNode.Me, node.Me)
+	data, err := plainNode.MessageHandler.SerializeMessage(store)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := node.MessageHandler.DeserializeMessage(data); err == nil {
+		t.Errorf("Expected plaintext request to be rejected")
+	}
+
+	// Pings exchange the keys and may be sent in plaintext
+	ping := kademlia.NewRPC(kademlia.PingRequest, false, kademlia.NewRandomKademliaID(), nil, plainNode.Me, node.Me)
+	data, _ = plainNode.MessageHandler.SerializeMessage(ping)
+	if _, err := node.MessageHandler.DeserializeMessage(data); err != nil {
+		t.Errorf("Expected plaintext ping to be accepted, got %v", err)
+	}
+}
+
+func TestEncryptedMessageIsNotCompressed(t *testing.T) {
+	kademlia.Compression = true
+	defer func() { kademlia.Compression = false }()
+
+	sender := initEncryptedNode(t)
+	recipient := initEncryptedNode(t)
+	recipient.Me.Id = kademlia.NewRandomKademliaID()
+	recipient.Me.Compression = true
+
+	data := bytes.Repeat([]byte("data "), 1000)
+	rpc := kademlia.NewRPC(kademlia.StoreRequest, false, kademlia.NewRandomKademliaID(), kademlia.NewPayload(nil, data, nil), sender.Me, recipient.Me)
+	serialized, err := sender.MessageHandler.SerializeMessage(rpc)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(serialized) < len(data) {
+		t.Errorf("Expected the encrypted message to be uncompressed, got %d bytes", len(serialized))
+	}
+	received, err := recipient.MessageHandler.DeserializeMessage(serialized)
+	if err != nil || !bytes.Equal(received.Payload.Data, data) {
+		t.Errorf("Expected payload data to be received, got %v", err)
+	}
+}
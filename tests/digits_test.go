@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"fmt"
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	mocks "kadlab-group-6/pkg/mocks"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// initDigitNode creates a node with a routing table grouping IDs into digits of b bits
+func initDigitNode(k int, b int) *kademlia.Node {
+	kademlia.DigitBits = b
+	defer func() { kademlia.DigitBits = 1 }()
+
+	node := &kademlia.Node{
+		K:  k,
+		Me: kademlia.NewContact(kademlia.NewKademliaID("0000000000000000000000000000000000000000"), "", 0),
+	}
+	node.RoutingTable = kademlia.NewRoutingTable(node)
+	node.MessageHandler = mocks.NewMockMessageHandler(node)
+	node.Network = mocks.NewMockNetwork(node)
+	return node
+}
+
+func TestDigitRoutingTableBuckets(t *testing.T) {
+	node := initDigitNode(20, 2)
+	addContacts(node,
+		"4000000000000000000000000000000000000000",
+		"8000000000000000000000000000000000000000",
+		"C000000000000000000000000000000000000000",
+		"C100000000000000000000000000000000000000",
+		"1000000000000000000000000000000000000000")
+
+	stats := node.RoutingTable.GetBucketStats()
+	// 3 buckets for every digit, the 4th value is our own
+	if len(stats) != kademlia.IDLength*8/2*3 {
+		t.Fatalf("Expected %d buckets, got %d", kademlia.IDLength*8/2*3, len(stats))
+	}
+	expected := []struct {
+		prefix   string
+		contacts int
+	}{{"01", 1}, {"10", 1}, {"11", 2}, {"0001", 1}, {"0010", 0}, {"0011", 0}}
+	for i, e := range expected {
+		if stats[i].Prefix != e.prefix || stats[i].Contacts != e.contacts {
+			t.Errorf("Expected %d contacts in bucket %s, got %v", e.contacts, e.prefix, stats[i])
+		}
+	}
+}
+
+func TestDigitRoutingTableShortLastDigit(t *testing.T) {
+	// With 3 bit digits the last digit of a 160 bit ID has a single bit
+	node := initDigitNode(20, 3)
+	stats := node.RoutingTable.GetBucketStats()
+	buckets := kademlia.IDLength*8/3*7 + 1
+	if len(stats) != buckets {
+		t.Fatalf("Expected %d buckets, got %d", buckets, len(stats))
+	}
+	if targets := node.RoutingTable.GetRefreshTargets(time.Now()); len(targets) != buckets {
+		t.Errorf("Expected %d refresh targets, got %d", buckets, len(targets))
+	}
+	last := stats[len(stats)-1]
+	if len(last.Prefix) != kademlia.IDLength*8 {
+		t.Errorf("Expected the last bucket to cover a full ID, got prefix %s", last.Prefix)
+	}
+
+	// The only other ID of the last digit falls into its bucket
+	id := kademlia.NewKademliaID("0000000000000000000000000000000000000001")
+	node.RoutingTable.AddContact(kademlia.NewContact(id, "127.0.0.1", 8000))
+	if after := node.RoutingTable.GetBucketStats()[len(stats)-1]; after.Contacts != 1 {
+		t.Errorf("Expected %s in the last bucket, got %v", id, after)
+	}
+}
+
+func TestSetDigitBits(t *testing.T) {
+	t.Cleanup(func() { kademlia.DigitBits = 1 })
+	for _, b := range []int{0, -1, kademlia.MaxDigitBits + 1} {
+		if err := kademlia.SetDigitBits(b); err == nil {
+			t.Errorf("Expected error for %d digit bits", b)
+		}
+	}
+	if err := kademlia.SetDigitBits(4); err != nil || kademlia.DigitBits != 4 {
+		t.Errorf("Expected 4 digit bits, got %d, %v", kademlia.DigitBits, err)
+	}
+}
+
+func TestDigitRoutingTableRefreshTargets(t *testing.T) {
+	node := initDigitNode(20, 4)
+	stats := node.RoutingTable.GetBucketStats()
+	targets := node.RoutingTable.GetRefreshTargets(time.Now())
+	if len(targets) != len(stats) {
+		t.Fatalf("Expected a target for each of the %d buckets, got %d", len(stats), len(targets))
+	}
+	// Every target falls into the bucket it refreshes
+	for i, target := range targets {
+		node.RoutingTable.AddContact(&kademlia.Contact{Id: target})
+		if after := node.RoutingTable.GetBucketStats()[i]; after.Contacts != 1 {
+			t.Errorf("Expected target %s in bucket %s, got %v", target, stats[i].Prefix, after)
+		}
+	}
+}
+
+func TestDigitRoutingTableFindClosestContacts(t *testing.T) {
+	node := initDigitNode(2, 2)
+	addContacts(node,
+		"F000000000000000000000000000000000000000",
+		"1000000000000000000000000000000000000000",
+		"2000000000000000000000000000000000000000",
+		"4000000000000000000000000000000000000000")
+
+	// The closest contacts are in the buckets of different digits
+	contacts := node.RoutingTable.FindClosestContacts(kademlia.NewKademliaID("3000000000000000000000000000000000000000"))
+	if len(contacts) != 2 || contacts[0].Id.String() != "2000000000000000000000000000000000000000" ||
+		contacts[1].Id.String() != "1000000000000000000000000000000000000000" {
+		t.Errorf("Expected 2000.. and 1000.., got %v", contacts)
+	}
+}
+
+func TestMemoryNetworkDigitLookup(t *testing.T) {
+	kademlia.DigitBits = 4
+	defer func() { kademlia.DigitBits = 1 }()
+
+	hub := kademlia.NewMemoryHub()
+	nodes := initMemoryNetwork(t, hub, 20)
+
+	target := nodes[len(nodes)-1].Me
+	contacts, stats := nodes[1].LookupContactWithStats(kademlia.NewContact(target.Id, "", 0))
+	found := false
+	for _, contact := range contacts {
+		if contact.Id.Equals(target.Id) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected lookup to find %s, got %v", target.Id, contacts)
+	}
+	if stats.Rounds == 0 || stats.Queries < stats.Rounds || stats.Hops > stats.Rounds {
+		t.Errorf("Expected consistent lookup stats, got %+v", stats)
+	}
+}
+
+// initSimulator creates count nodes with digits of b bits connected to the same hub.
+// Every node is offered every other node in random order, so that the routing
+// tables are as complete as their buckets allow
+func initSimulator(b int, count int, k int, alpha int) []*kademlia.Node {
+	kademlia.DigitBits = b
+	defer func() { kademlia.DigitBits = 1 }()
+
+	hub := kademlia.NewMemoryHub()
+	var nodes []*kademlia.Node
+	for i := 0; i < count; i++ {
+		nodes = append(nodes, kademlia.NewMemoryNode(hub, kademlia.NewRandomKademliaID(), k, alpha))
+	}
+	for _, node := range nodes {
+		for _, i := range rand.Perm(count) {
+			node.RoutingTable.AddContact(nodes[i].Me)
+		}
+	}
+	for _, node := range nodes {
+		fixedTable(node).WaitForEvictions()
+	}
+	return nodes
+}
+
+// BenchmarkLookupDigitBits compares the hops of lookups with digits of 1 bit,
+// the GetBucketIndex layout, to larger digits
+func BenchmarkLookupDigitBits(b *testing.B) {
+	for _, bits := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("b=%d", bits), func(b *testing.B) {
+			nodes := initSimulator(bits, 256, 3, 1)
+			var hops, rounds, queries int
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				source := nodes[rand.Intn(len(nodes))]
+				target := nodes[rand.Intn(len(nodes))]
+				_, stats := source.LookupContactWithStats(kademlia.NewContact(target.Me.Id, "", 0))
+				hops += stats.Hops
+				rounds += stats.Rounds
+				queries += stats.Queries
+			}
+			b.ReportMetric(float64(hops)/float64(b.N), "hops/lookup")
+			b.ReportMetric(float64(rounds)/float64(b.N), "rounds/lookup")
+			b.ReportMetric(float64(queries)/float64(b.N), "queries/lookup")
+		})
+	}
+}
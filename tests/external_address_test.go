@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"fmt"
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"testing"
+	"time"
+)
+
+func TestExternalAddressQuorum(t *testing.T) {
+	external := kademlia.NewExternalAddress()
+
+	if agreed := external.Report("10.0.1.1", "1.2.3.4"); agreed != "" {
+		t.Errorf("Expected no quorum after one report, got %s", agreed)
+	}
+	if agreed := external.Report("10.0.2.1", "5.6.7.8"); agreed != "" {
+		t.Errorf("Expected no quorum for disagreeing reports, got %s", agreed)
+	}
+	if agreed := external.Report("10.0.3.1", "1.2.3.4"); agreed != "" {
+		t.Errorf("Expected no quorum after two reports, got %s", agreed)
+	}
+	if agreed := external.Report("10.0.4.1", "1.2.3.4"); agreed != "1.2.3.4" {
+		t.Errorf("Expected quorum on 1.2.3.4, got %s", agreed)
+	}
+	if len(external.Reports) != 0 {
+		t.Errorf("Expected reports to be cleared after a quorum, got %d", len(external.Reports))
+	}
+}
+
+func TestExternalAddressSameNetwork(t *testing.T) {
+	external := kademlia.NewExternalAddress()
+
+	// Peers in one network count once, whatever node IDs they claim
+	for i := 0; i < kademlia.ExternalAddressQuorum; i++ {
+		if agreed := external.Report(fmt.Sprintf("10.0.0.%d", i+1), "1.2.3.4"); agreed != "" {
+			t.Errorf("Expected no quorum from a single network, got %s", agreed)
+		}
+	}
+}
+
+func TestExternalAddressReportsExpireAndAreCapped(t *testing.T) {
+	external := kademlia.NewExternalAddress()
+	maxReports, ttl := kademlia.MaxExternalReports, kademlia.ExternalReportTTL
+	kademlia.MaxExternalReports = 4
+	defer func() { kademlia.MaxExternalReports, kademlia.ExternalReportTTL = maxReports, ttl }()
+
+	for i := 0; i < 10; i++ {
+		external.Report(fmt.Sprintf("10.0.%d.1", i), fmt.Sprintf("1.2.3.%d", i))
+	}
+	if len(external.Reports) != 4 {
+		t.Errorf("Expected 4 reports, got %d", len(external.Reports))
+	}
+
+	// Old reports no longer count towards a quorum
+	kademlia.ExternalReportTTL = 0
+	time.Sleep(time.Millisecond)
+	external.Report("10.1.0.1", "1.2.3.4")
+	if len(external.Reports) != 1 {
+		t.Errorf("Expected expired reports to be removed, got %d", len(external.Reports))
+	}
+}
@@ -0,0 +1,208 @@
+package tests
+
+import (
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"testing"
+	"time"
+)
+
+// initFaultyNode creates a node connected to the hub through the injector
+func initFaultyNode(hub *kademlia.MemoryHub, injector *kademlia.FaultInjector, id *kademlia.KademliaID, k int) *kademlia.Node {
+	node := kademlia.NewMemoryNode(hub, id, k, 3)
+	node.Network = kademlia.NewFaultyNetwork(node.Network, injector)
+	return node
+}
+
+func TestFaultyNetworkPartition(t *testing.T) {
+	hub := kademlia.NewMemoryHub()
+	injector := kademlia.NewFaultInjector(1)
+	a := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+	b := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+	c := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+
+	injector.Partition([]*kademlia.KademliaID{a.Me.Id}, []*kademlia.KademliaID{b.Me.Id})
+	if _, err := a.MessageHandler.SendPingRequest(a.Me, b.Me); err == nil {
+		t.Errorf("Expected ping across the partition to fail")
+	}
+	// Nodes outside of all groups reach everyone
+	if _, err := a.MessageHandler.SendPingRequest(a.Me, c.Me); err != nil {
+		t.Errorf("Expected ping to node outside the partition to succeed, got %v", err)
+	}
+
+	injector.Heal()
+	if _, err := a.MessageHandler.SendPingRequest(a.Me, b.Me); err != nil {
+		t.Errorf("Expected ping after healing to succeed, got %v", err)
+	}
+}
+
+func TestFaultyNetworkLoss(t *testing.T) {
+	hub := kademlia.NewMemoryHub()
+	injector := kademlia.NewFaultInjector(1)
+	a := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+	b := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+	c := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+
+	injector.SetLinkFaults(a.Me.Id, b.Me.Id, kademlia.LinkFaults{Loss: 1})
+	if _, err := a.MessageHandler.SendPingRequest(a.Me, b.Me); err == nil {
+		t.Errorf("Expected ping over a lossy link to fail")
+	}
+	if injector.Counters.Get(kademlia.CounterFaultDropped) != 1 {
+		t.Errorf("Expected 1 dropped message, got %d", injector.Counters.Get(kademlia.CounterFaultDropped))
+	}
+	// Other links are unaffected
+	if _, err := a.MessageHandler.SendPingRequest(a.Me, c.Me); err != nil {
+		t.Errorf("Expected ping over another link to succeed, got %v", err)
+	}
+
+	injector.ClearLinkFaults(a.Me.Id, b.Me.Id)
+	if _, err := a.MessageHandler.SendPingRequest(a.Me, b.Me); err != nil {
+		t.Errorf("Expected ping after clearing the faults to succeed, got %v", err)
+	}
+}
+
+func TestFaultyNetworkLatency(t *testing.T) {
+	hub := kademlia.NewMemoryHub()
+	injector := kademlia.NewFaultInjector(1)
+	a := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+	b := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+
+	injector.SetDefaultFaults(kademlia.LinkFaults{Latency: 20 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	start := time.Now()
+	if _, err := a.MessageHandler.SendPingRequest(a.Me, b.Me); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Both the request and the response are delayed
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Expected round trip of at least 40ms, got %v", elapsed)
+	}
+}
+
+func TestFaultyNetworkDuplication(t *testing.T) {
+	hub := kademlia.NewMemoryHub()
+	injector := kademlia.NewFaultInjector(1)
+	a := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+	b := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+
+	injector.SetDefaultFaults(kademlia.LinkFaults{Duplication: 1, Reorder: 1})
+	if _, err := a.MessageHandler.SendPingRequest(a.Me, b.Me); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	hub.Wait()
+	// The request, its response and the response to the duplicated request
+	if injector.Counters.Get(kademlia.CounterFaultDuplicated) != 3 {
+		t.Errorf("Expected 3 duplicated messages, got %d", injector.Counters.Get(kademlia.CounterFaultDuplicated))
+	}
+	if injector.Counters.Get(kademlia.CounterFaultReordered) != 3 {
+		t.Errorf("Expected 3 reordered messages, got %d", injector.Counters.Get(kademlia.CounterFaultReordered))
+	}
+}
+
+func TestFaultyNetworkRequestDuplication(t *testing.T) {
+	hub := kademlia.NewMemoryHub()
+	injector := kademlia.NewFaultInjector(1)
+	a := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+	b := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+
+	injector.SetLinkFaults(a.Me.Id, b.Me.Id, kademlia.LinkFaults{Duplication: 1})
+	if _, err := a.MessageHandler.SendPingRequest(a.Me, b.Me); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	hub.Wait()
+
+	// The duplicated request is answered from the response cache of the destination
+	handler := b.MessageHandler.(*kademlia.MessageHandler)
+	if handler.Counters.Get(kademlia.CounterDuplicateRequests) != 1 {
+		t.Errorf("Expected 1 duplicate request, got %d", handler.Counters.Get(kademlia.CounterDuplicateRequests))
+	}
+}
+
+func TestFaultyNetworkDropTimeout(t *testing.T) {
+	timeout := kademlia.MemoryTimeout
+	kademlia.MemoryTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { kademlia.MemoryTimeout = timeout })
+
+	hub := kademlia.NewMemoryHub()
+	injector := kademlia.NewFaultInjector(1)
+	a := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+	b := initFaultyNode(hub, injector, kademlia.NewRandomKademliaID(), 20)
+
+	// Dropped requests fail after the timeout of the wrapped network
+	injector.SetDefaultFaults(kademlia.LinkFaults{Loss: 1})
+	start := time.Now()
+	if _, err := a.MessageHandler.SendPingRequest(a.Me, b.Me); err == nil {
+		t.Fatalf("Expected ping over a lossy link to fail")
+	}
+	if elapsed := time.Since(start); elapsed < kademlia.MemoryTimeout {
+		t.Errorf("Expected dropped request to fail after %v, got %v", kademlia.MemoryTimeout, elapsed)
+	}
+}
+
+func TestFaultyNetworkLookupTerminates(t *testing.T) {
+	timeout := kademlia.MemoryTimeout
+	kademlia.MemoryTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { kademlia.MemoryTimeout = timeout })
+
+	hub := kademlia.NewMemoryHub()
+	// Requests may still be processed when the lookup returns
+	t.Cleanup(hub.Wait)
+	// Fixed IDs and a seeded injector, the network is built before any loss
+	injector := kademlia.NewFaultInjector(1)
+	nodes := []*kademlia.Node{initFaultyNode(hub, injector, faultyTestID(0), 5)}
+	for i := 1; i < 15; i++ {
+		node := initFaultyNode(hub, injector, faultyTestID(i), 5)
+		if err := node.Join(nodes[0].Me); err != nil {
+			t.Fatalf("Expected node %d to join, got %v", i, err)
+		}
+		nodes = append(nodes, node)
+	}
+	hub.Wait()
+
+	injector.SetDefaultFaults(kademlia.LinkFaults{Loss: 0.1})
+	done := make(chan struct{})
+	go func() {
+		nodes[1].LookupContact(kademlia.NewContact(faultyTestID(100), "", 0))
+		close(done)
+	}()
+
+	// Which messages are lost depends on scheduling, so only termination is checked
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Errorf("Expected lookup to terminate under packet loss")
+	}
+}
+
+// faultyTestID returns a fixed ID spread over the ID space
+func faultyTestID(i int) *kademlia.KademliaID {
+	id := kademlia.KademliaID{}
+	for j := 0; j < kademlia.IDLength; j++ {
+		id[j] = byte(i*37 + j*11)
+	}
+	return &id
+}
+
+func TestFaultyNetworkBucketEviction(t *testing.T) {
+	hub := kademlia.NewMemoryHub()
+	injector := kademlia.NewFaultInjector(1)
+	node := initFaultyNode(hub, injector, kademlia.NewKademliaID("0000000000000000000000000000000000000000"), 2)
+
+	// All contacts end up in the same bucket of the node
+	first := initFaultyNode(hub, injector, kademlia.NewKademliaID("8000000000000000000000000000000000000000"), 2)
+	second := initFaultyNode(hub, injector, kademlia.NewKademliaID("8100000000000000000000000000000000000000"), 2)
+	third := initFaultyNode(hub, injector, kademlia.NewKademliaID("8200000000000000000000000000000000000000"), 2)
+
+	node.RoutingTable.AddContact(first.Me)
+	node.RoutingTable.AddContact(second.Me)
+
+	// The least recently seen contact is unreachable and gets evicted
+	injector.Partition([]*kademlia.KademliaID{node.Me.Id, second.Me.Id, third.Me.Id}, []*kademlia.KademliaID{first.Me.Id})
+	node.RoutingTable.AddContact(third.Me)
+	fixedTable(node).WaitForEvictions()
+
+	if hasContact(node, first.Me.Id.String()) {
+		t.Errorf("Expected unreachable contact %s to be evicted", first.Me.Id)
+	}
+	if !hasContact(node, third.Me.Id.String()) {
+		t.Errorf("Expected contact %s to replace the evicted contact", third.Me.Id)
+	}
+}
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	kademlia "kadlab-group-6/pkg/kademlia_node"
 	"testing"
 )
@@ -122,3 +123,68 @@ func TestKademliaID_String(t *testing.T) {
 		t.Errorf("Expected string representation to be %s, got %s", data, id.String())
 	}
 }
+
+func TestNewSecureRandomKademliaID(t *testing.T) {
+	id1 := kademlia.NewSecureRandomKademliaID()
+	id2 := kademlia.NewSecureRandomKademliaID()
+
+	t.Logf("Generated secure random KademliaID1: %s", id1.String())
+	t.Logf("Generated secure random KademliaID2: %s", id2.String())
+
+	if id1.Equals(id2) {
+		t.Errorf("Expected two random KademliaIDs to be different, but they are the same")
+	}
+}
+
+func TestSetIDLength(t *testing.T) {
+	if err := kademlia.SetIDLength(0); err == nil {
+		t.Errorf("Expected ID length 0 to be rejected")
+	}
+	if err := kademlia.SetIDLength(kademlia.MaxIDLength + 1); err == nil {
+		t.Errorf("Expected ID length above %d to be rejected", kademlia.MaxIDLength)
+	}
+	if kademlia.IDLength != 20 {
+		t.Errorf("Expected invalid ID lengths to not change the ID length, got %d", kademlia.IDLength)
+	}
+
+	if err := kademlia.SetIDLength(8); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer kademlia.SetIDLength(20)
+
+	id := kademlia.NewRandomKademliaID()
+	if len(id.String()) != 16 {
+		t.Errorf("Expected 64 bit ID to have 16 hex digits, got %s", id)
+	}
+	for i := 8; i < kademlia.MaxIDLength; i++ {
+		if id[i] != 0 || kademlia.NewSecureRandomKademliaID()[i] != 0 {
+			t.Errorf("Expected byte %d beyond the ID length to be zero", i)
+		}
+	}
+	reference := kademlia.NewRandomKademliaID()
+	if index := kademlia.GetBucketIndex(kademlia.NewRandomKademliaIDInBucket(10, reference), reference); index != 64-1-10 {
+		t.Errorf("Expected random ID in bucket %d, got %d", 64-1-10, index)
+	}
+}
+
+func TestKademliaIDJSON(t *testing.T) {
+	id := kademlia.NewKademliaID("00112233445566778899aabbccddeeff00112233")
+	data, err := json.Marshal(id)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(data) != `"00112233445566778899aabbccddeeff00112233"` {
+		t.Errorf("Expected hex encoded ID, got %s", data)
+	}
+
+	var decoded kademlia.KademliaID
+	if err := json.Unmarshal(data, &decoded); err != nil || !decoded.Equals(id) {
+		t.Errorf("Expected %s, got %s (%v)", id, decoded.String(), err)
+	}
+	if err := json.Unmarshal([]byte(`"0011"`), &decoded); err == nil {
+		t.Errorf("Expected ID of another length to be rejected")
+	}
+	if err := json.Unmarshal([]byte(`"zz"`), &decoded); err == nil {
+		t.Errorf("Expected invalid hex to be rejected")
+	}
+}
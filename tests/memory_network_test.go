@@ -0,0 +1,133 @@
+package tests
+
+import (
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"testing"
+	"time"
+)
+
+// initMemoryNetwork creates count nodes connected to the same hub
+// that have all joined the network through the first node
+func initMemoryNetwork(t *testing.T, hub *kademlia.MemoryHub, count int) []*kademlia.Node {
+	// Let the requests still in flight finish before the next test changes the configuration
+	t.Cleanup(hub.Wait)
+	nodes := []*kademlia.Node{kademlia.NewMemoryNode(hub, kademlia.NewRandomKademliaID(), 5, 3)}
+	for i := 1; i < count; i++ {
+		node := kademlia.NewMemoryNode(hub, kademlia.NewRandomKademliaID(), 5, 3)
+		if err := node.Join(nodes[0].Me); err != nil {
+			t.Fatalf("Expected node %d to join, got %v", i, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func TestMemoryNetworkPing(t *testing.T) {
+	hub := kademlia.NewMemoryHub()
+	a := kademlia.NewMemoryNode(hub, kademlia.NewRandomKademliaID(), 20, 3)
+	b := kademlia.NewMemoryNode(hub, kademlia.NewRandomKademliaID(), 20, 3)
+
+	response, err := a.MessageHandler.SendPingRequest(a.Me, b.Me)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if response.Type != kademlia.PingResponse || !response.Source.Id.Equals(b.Me.Id) {
+		t.Errorf("Expected ping response from %s, got %v", b.Me.Id, response)
+	}
+
+	// The requester has been added to the routing table of the destination
+	contacts := b.RoutingTable.FindClosestContacts(a.Me.Id)
+	if len(contacts) != 1 || !contacts[0].Id.Equals(a.Me.Id) {
+		t.Errorf("Expected %s in the routing table, got %v", a.Me.Id, contacts)
+	}
+}
+
+func TestMemoryNetworkRecordsLiveness(t *testing.T) {
+	hub := kademlia.NewMemoryHub()
+	a := kademlia.NewMemoryNode(hub, kademlia.NewRandomKademliaID(), 20, 3)
+	b := kademlia.NewMemoryNode(hub, kademlia.NewRandomKademliaID(), 20, 3)
+
+	// The request of b adds it to the routing table of a, the response to a updates it
+	if _, err := b.MessageHandler.SendPingRequest(b.Me, a.Me); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := a.MessageHandler.SendPingRequest(a.Me, b.Me); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	stats := a.RoutingTable.GetContactStats()
+	if len(stats) != 1 || stats[0].Id != b.Me.Id.String() || stats[0].LastRTT <= 0 || stats[0].SmoothedRTT <= 0 {
+		t.Errorf("Expected the RTT of %s to be recorded, got %+v", b.Me.Id, stats)
+	}
+}
+
+func TestMemoryNetworkUnknownDestination(t *testing.T) {
+	timeout := kademlia.MemoryTimeout
+	kademlia.MemoryTimeout = 50 * time.Millisecond
+	defer func() { kademlia.MemoryTimeout = timeout }()
+
+	hub := kademlia.NewMemoryHub()
+	a := kademlia.NewMemoryNode(hub, kademlia.NewRandomKademliaID(), 20, 3)
+	b := kademlia.NewMemoryNode(hub, kademlia.NewRandomKademliaID(), 20, 3)
+	hub.Unregister(b.Network.(*kademlia.MemoryNetwork))
+
+	if _, err := a.MessageHandler.SendPingRequest(a.Me, b.Me); err == nil {
+		t.Errorf("Expected timeout for unregistered node")
+	}
+}
+
+func TestMemoryNetworkLookup(t *testing.T) {
+	hub := kademlia.NewMemoryHub()
+	nodes := initMemoryNetwork(t, hub, 20)
+
+	target := nodes[len(nodes)-1].Me
+	contacts := nodes[1].LookupContact(kademlia.NewContact(target.Id, "", 0))
+
+	found := false
+	for _, contact := range contacts {
+		if contact.Id.Equals(target.Id) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected lookup to find %s, got %v", target.Id, contacts)
+	}
+}
+
+func TestMemoryNetworkRefreshStaleBuckets(t *testing.T) {
+	hub := kademlia.NewMemoryHub()
+	nodes := initMemoryNetwork(t, hub, 10)
+
+	// A node that only knows the bootstrap node learns about the others
+	// by refreshing its buckets
+	node := kademlia.NewMemoryNode(hub, kademlia.NewRandomKademliaID(), 5, 3)
+	node.RoutingTable.AddContact(nodes[0].Me)
+	since := time.Now().Add(time.Second)
+	node.RefreshStaleBuckets(since)
+	fixedTable(node).WaitForEvictions()
+
+	if contacts := node.RoutingTable.FindClosestContacts(node.Me.Id); len(contacts) <= 1 {
+		t.Errorf("Expected refresh to find more contacts, got %v", contacts)
+	}
+	// Every bucket has been looked up
+	if targets := node.RoutingTable.GetRefreshTargets(since.Add(-time.Second)); len(targets) != 0 {
+		t.Errorf("Expected no stale buckets after refresh, got %v", targets)
+	}
+}
+
+func TestMemoryNetworkShortIDs(t *testing.T) {
+	kademlia.SetIDLength(8)
+	// Cleanups run in reverse order, so the requests of the hub finish first
+	t.Cleanup(func() { kademlia.SetIDLength(20) })
+
+	hub := kademlia.NewMemoryHub()
+	nodes := initMemoryNetwork(t, hub, 10)
+	if stats := nodes[0].RoutingTable.GetBucketStats(); len(stats) != 64 {
+		t.Errorf("Expected 64 buckets, got %d", len(stats))
+	}
+
+	target := nodes[len(nodes)-1].Me
+	contacts := nodes[1].LookupContact(kademlia.NewContact(target.Id, "", 0))
+	if len(contacts) == 0 || !contacts[0].Id.Equals(target.Id) {
+		t.Errorf("Expected lookup to find %s, got %v", target.Id, contacts)
+	}
+}
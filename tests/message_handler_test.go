@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	kademlia "kadlab-group-6/pkg/kademlia_node"
 	mocks "kadlab-group-6/pkg/mocks"
+	"net"
+	"strings"
 	"testing"
+	"time"
 )
 
 func initNode() *kademlia.Node {
@@ -167,3 +170,230 @@ func TestSendPingResponse(t *testing.T) {
 		t.Errorf("Expected RPC: %s, got %s", expectedRPC, requestRPC)
 	}
 }
+
+func TestProcessRequestHeaderMismatch(t *testing.T) {
+	node := initNode()
+	handler := node.MessageHandler.(*kademlia.MessageHandler)
+
+	source := kademlia.NewContact(kademlia.NewRandomKademliaID(), "", 0)
+	requestRPC := kademlia.NewRPC(kademlia.PingRequest, false, kademlia.NewRandomKademliaID(), nil, source, node.Me)
+	requestRPC.Header.NetworkID = "other-network"
+
+	_, err := handler.ProcessRequest(requestRPC)
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	if handler.Counters.Get(kademlia.CounterHeaderMismatch) != 1 {
+		t.Errorf("Expected 1 header mismatch, got %d", handler.Counters.Get(kademlia.CounterHeaderMismatch))
+	}
+
+	// The sender must not be added to the routing table
+	for _, contact := range node.RoutingTable.FindClosestContacts(source.Id) {
+		if contact.Id.Equals(source.Id) {
+			t.Errorf("Expected contact %v to not be in the routing table", source)
+		}
+	}
+}
+
+func TestDeserializeUnauthenticatedMessage(t *testing.T) {
+	node := initNode()
+	handler := node.MessageHandler.(*kademlia.MessageHandler)
+
+	source := kademlia.NewContact(kademlia.NewRandomKademliaID(), "", 0)
+	rpc := kademlia.NewRPC(kademlia.PingRequest, false, kademlia.NewRandomKademliaID(), nil, source, node.Me)
+	unsigned, _ := json.Marshal(rpc)
+
+	kademlia.ClusterKey = []byte("secret")
+	defer func() { kademlia.ClusterKey = nil }()
+
+	if _, err := handler.DeserializeMessage(unsigned); err == nil {
+		t.Errorf("Expected unauthenticated message to be rejected")
+	}
+	if handler.Counters.Get(kademlia.CounterUnauthenticated) != 1 {
+		t.Errorf("Expected 1 unauthenticated message, got %d", handler.Counters.Get(kademlia.CounterUnauthenticated))
+	}
+
+	signed, err := handler.SerializeMessage(rpc)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	deserializedRPC, err := handler.DeserializeMessage(signed)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !deserializedRPC.ID.Equals(rpc.ID) {
+		t.Errorf("Expected ID %s, got %s", rpc.ID, deserializedRPC.ID)
+	}
+}
+
+func TestSendPingResponseObservedAddress(t *testing.T) {
+	node := initNode()
+
+	source := kademlia.NewContact(kademlia.NewRandomKademliaID(), "10.0.0.1", 8000)
+	requestRPC := kademlia.NewRPC(kademlia.PingRequest, false, kademlia.NewRandomKademliaID(), nil, source, node.Me)
+	requestRPC.From = &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 4321}
+
+	responseRPC := node.MessageHandler.SendPingResponse(requestRPC)
+	if responseRPC.Payload == nil {
+		t.Fatalf("Expected payload with the observed address")
+	}
+	if responseRPC.Payload.ObservedIp != "1.2.3.4" {
+		t.Errorf("Expected observed address 1.2.3.4, got %s", responseRPC.Payload.ObservedIp)
+	}
+}
+
+func TestProcessDuplicateRequest(t *testing.T) {
+	node := initNode()
+	handler := node.MessageHandler.(*kademlia.MessageHandler)
+	network := node.Network.(*mocks.MockNetwork)
+
+	source := kademlia.NewContact(kademlia.NewRandomKademliaID(), "127.0.0.1", 8001)
+	requestRPC := kademlia.NewRPC(kademlia.PingRequest, false, kademlia.NewRandomKademliaID(), nil, source, node.Me)
+
+	first, err := handler.ProcessRequest(requestRPC)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	second, err := handler.ProcessRequest(requestRPC)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if first != second {
+		t.Errorf("Expected the cached response %v, got %v", first, second)
+	}
+	if handler.Counters.Get(kademlia.CounterDuplicateRequests) != 1 {
+		t.Errorf("Expected 1 duplicate request, got %d", handler.Counters.Get(kademlia.CounterDuplicateRequests))
+	}
+	// The cached response is sent again since the first one may have been lost
+	if len(network.GetSentMessages()) != 2 {
+		t.Errorf("Expected 2 sent responses, got %d", len(network.GetSentMessages()))
+	}
+}
+
+func TestProcessDuplicateStoreRequest(t *testing.T) {
+	node := initNode()
+	handler := node.MessageHandler.(*kademlia.MessageHandler)
+	network := node.Network.(*mocks.MockNetwork)
+
+	source := kademlia.NewContact(kademlia.NewRandomKademliaID(), "127.0.0.1", 8001)
+	payload := kademlia.NewPayload(nil, []byte("data"), nil)
+	requestRPC := kademlia.NewRPC(kademlia.StoreRequest, false, kademlia.NewRandomKademliaID(), payload, source, node.Me)
+
+	first, err := handler.ProcessRequest(requestRPC)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	sent := len(network.GetSentMessages())
+	second, err := handler.ProcessRequest(requestRPC)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if first != second {
+		t.Errorf("Expected the cached response %v, got %v", first, second)
+	}
+	// The duplicate is answered like the first request, which sent nothing
+	if len(network.GetSentMessages()) != sent {
+		t.Errorf("Expected %d sent responses, got %d", sent, len(network.GetSentMessages()))
+	}
+}
+
+func TestProcessRequestWithRoutingTableInterface(t *testing.T) {
+	node := initNode()
+	table := mocks.NewMockRoutingTable()
+	node.RoutingTable = table
+	known := kademlia.NewContact(kademlia.NewRandomKademliaID(), "10.0.0.1", 8000)
+	table.AddContact(known)
+
+	source := kademlia.NewContact(kademlia.NewRandomKademliaID(), "10.0.0.2", 8000)
+	payload := kademlia.NewPayload(kademlia.NewRandomKademliaID(), nil, nil)
+	request := kademlia.NewRPC(kademlia.FindNodeRequest, false, kademlia.NewRandomKademliaID(), payload, source, node.Me)
+	response, err := node.MessageHandler.ProcessRequest(request)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The requester is added to the routing table before the closest contacts are found
+	if len(table.Contacts) != 2 || !table.Contacts[1].Id.Equals(source.Id) {
+		t.Errorf("Expected %s to be added to the routing table, got %v", source.Id, table.Contacts)
+	}
+	if len(response.Payload.Contacts) != 2 || !response.Payload.Contacts[0].Id.Equals(known.Id) {
+		t.Errorf("Expected the contacts of the routing table in the response, got %v", response.Payload.Contacts)
+	}
+}
+
+func TestSendRequestRecordsOutcome(t *testing.T) {
+	node := initNode()
+	table := mocks.NewMockRoutingTable()
+	node.RoutingTable = table
+	destination := kademlia.NewContact(kademlia.NewRandomKademliaID(), "10.0.0.1", 8000)
+
+	if _, err := node.MessageHandler.SendPingRequest(node.Me, destination); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(table.Succeeded) != 1 || len(table.Failed) != 0 {
+		t.Errorf("Expected 1 success and no failures, got %d and %d", len(table.Succeeded), len(table.Failed))
+	}
+}
+
+func TestUnresponsiveContactIsEvicted(t *testing.T) {
+	timeout := kademlia.MemoryTimeout
+	kademlia.MemoryTimeout = 20 * time.Millisecond
+	defer func() { kademlia.MemoryTimeout = timeout }()
+
+	hub := kademlia.NewMemoryHub()
+	a := kademlia.NewMemoryNode(hub, kademlia.NewRandomKademliaID(), 20, 3)
+	b := kademlia.NewMemoryNode(hub, kademlia.NewRandomKademliaID(), 20, 3)
+	a.RoutingTable.AddContact(b.Me)
+	hub.Unregister(b.Network.(*kademlia.MemoryNetwork))
+
+	for i := 0; i < kademlia.FailureThreshold; i++ {
+		if !hasContact(a, b.Me.Id.String()) {
+			t.Fatalf("Expected contact to be evicted only after %d failures, got %d", kademlia.FailureThreshold, i)
+		}
+		a.MessageHandler.SendFindNodeRequest(a.Me, b.Me, a.Me.Id)
+	}
+	if hasContact(a, b.Me.Id.String()) {
+		t.Errorf("Expected unresponsive contact %s to be evicted", b.Me.Id)
+	}
+}
+
+func TestDeserializeMessageOtherIDLength(t *testing.T) {
+	node := initNode()
+
+	kademlia.SetIDLength(8)
+	source := kademlia.NewContact(kademlia.NewRandomKademliaID(), "10.0.0.1", 8000)
+	rpc := kademlia.NewRPC(kademlia.PingRequest, false, kademlia.NewRandomKademliaID(), nil, source, source)
+	data, err := node.MessageHandler.SerializeMessage(rpc)
+	kademlia.SetIDLength(20)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := node.MessageHandler.DeserializeMessage(data); err == nil {
+		t.Errorf("Expected message from node with another ID length to be rejected")
+	}
+	if count := node.MessageHandler.(*kademlia.MessageHandler).Counters.Get(kademlia.CounterHeaderMismatch); count != 1 {
+		t.Errorf("Expected 1 header mismatch, got %d", count)
+	}
+}
+
+func TestDeserializeMessageOtherVersion(t *testing.T) {
+	node := initNode()
+
+	// Older versions do not send the ID length in the header
+	kademlia.SetIDLength(8)
+	source := kademlia.NewContact(kademlia.NewRandomKademliaID(), "10.0.0.1", 8000)
+	rpc := kademlia.NewRPC(kademlia.PingRequest, false, kademlia.NewRandomKademliaID(), nil, source, source)
+	rpc.Header = &kademlia.Header{Version: kademlia.ProtocolVersion - 1}
+	data, err := node.MessageHandler.SerializeMessage(rpc)
+	kademlia.SetIDLength(20)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := node.MessageHandler.DeserializeMessage(data); err == nil || !strings.Contains(err.Error(), "protocol version mismatch") {
+		t.Errorf("Expected protocol version mismatch, got %v", err)
+	}
+}
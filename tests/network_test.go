@@ -157,3 +157,186 @@ func TestSendRequest(t *testing.T) {
 		t.Errorf("Expected response ID %v, got %v", requestRpc.ID, response.ID)
 	}
 }
+
+func TestSendRequestRecordsRTT(t *testing.T) {
+	node := initNodeNetwork()
+	network := kademlia.NewNetwork(node)
+
+	requestRpc := kademlia.NewRPC(kademlia.PingRequest, false, kademlia.NewRandomKademliaID(), nil, node.Me, node.Me)
+	_, err := network.SendRequest(requestRpc)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	stats, exists := network.RTT.GetStats(node.Me.Id)
+	if !exists || stats.Samples == 0 {
+		t.Errorf("Expected RTT samples for the destination, got %v", stats)
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	destination := kademlia.NewContact(kademlia.NewRandomKademliaID(), "127.0.0.1", 8000)
+	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:8000")
+	pending := &kademlia.PendingRequest{Destination: destination, Addr: addr}
+
+	response := kademlia.NewRPC(kademlia.PingResponse, true, kademlia.NewRandomKademliaID(), nil, destination, nil)
+	if err := kademlia.ValidateResponse(pending, response, addr); err != nil {
+		t.Errorf("Expected valid response, got %v", err)
+	}
+
+	// Responses may come from another port of the same host
+	otherPort, _ := net.ResolveUDPAddr("udp", "127.0.0.1:9000")
+	if err := kademlia.ValidateResponse(pending, response, otherPort); err != nil {
+		t.Errorf("Expected valid response, got %v", err)
+	}
+
+	otherHost, _ := net.ResolveUDPAddr("udp", "127.0.0.2:8000")
+	if err := kademlia.ValidateResponse(pending, response, otherHost); err == nil {
+		t.Errorf("Expected response from another host to be rejected")
+	}
+
+	response.Source = kademlia.NewContact(kademlia.NewRandomKademliaID(), "127.0.0.1", 8000)
+	if err := kademlia.ValidateResponse(pending, response, addr); err == nil {
+		t.Errorf("Expected response from another contact to be rejected")
+	}
+
+	response.Source = nil
+	if err := kademlia.ValidateResponse(pending, response, addr); err == nil {
+		t.Errorf("Expected response without source to be rejected")
+	}
+}
+
+func TestSendRequestDropsSpoofedResponse(t *testing.T) {
+	node := initNodeNetwork()
+	network := kademlia.NewNetwork(node)
+
+	timeout, retries := kademlia.Timeout, kademlia.Retries
+	kademlia.Timeout, kademlia.Retries = 200*time.Millisecond, 0
+	defer func() { kademlia.Timeout, kademlia.Retries = timeout, retries }()
+
+	// The listener echoes the request back with our own contact as source,
+	// which does not match the contact we asked
+	destination := kademlia.NewContact(kademlia.NewRandomKademliaID(), node.Me.Ip, node.Me.Port)
+	requestRpc := kademlia.NewRPC(kademlia.PingRequest, false, kademlia.NewRandomKademliaID(), nil, node.Me, destination)
+
+	dropped := network.Counters.Get(kademlia.CounterSpoofedResponse)
+	_, err := network.SendRequest(requestRpc)
+	if err == nil {
+		t.Errorf("Expected timeout, got response")
+	}
+	if network.Counters.Get(kademlia.CounterSpoofedResponse) != dropped+1 {
+		t.Errorf("Expected spoofed response to be counted")
+	}
+}
+
+func TestListenDropsUnexpectedResponse(t *testing.T) {
+	node := initNodeNetwork()
+	network := kademlia.NewNetwork(node)
+	go network.Listen()
+
+	conn, err := net.Dial("udp", node.Me.Address())
+	if err != nil {
+		t.Fatalf("Expected to connect to listener, got error: %v", err)
+	}
+	defer conn.Close()
+
+	// A response to a request that was never sent
+	response := kademlia.NewRPC(kademlia.PingResponse, true, kademlia.NewRandomKademliaID(), nil, node.Me, node.Me)
+	data, _ := node.MessageHandler.SerializeMessage(response)
+
+	unexpected := network.Counters.Get(kademlia.CounterUnexpectedResponse)
+	limited := network.Counters.Get(kademlia.CounterRateLimited)
+	queued := network.Counters.Get(kademlia.CounterRequestsQueued)
+	for i := 0; i < 20 && network.Counters.Get(kademlia.CounterUnexpectedResponse) == unexpected; i++ {
+		conn.Write(data)
+		time.Sleep(100 * time.Millisecond)
+	}
+	if network.Counters.Get(kademlia.CounterUnexpectedResponse) == unexpected {
+		t.Fatalf("Expected unexpected response to be counted")
+	}
+	if network.Counters.Get(kademlia.CounterRateLimited) != limited || network.Counters.Get(kademlia.CounterRequestsQueued) != queued {
+		t.Errorf("Expected unexpected response to skip the rate limiter and the request queue")
+	}
+}
+
+func TestSendResponseDropsWhenQueueFull(t *testing.T) {
+	network := &kademlia.Network{ResponseQueue: make(chan *kademlia.RPC, 1), Counters: kademlia.NewCounters()}
+	rpc := kademlia.NewRPC(kademlia.PingResponse, true, kademlia.NewRandomKademliaID(), nil, nil, nil)
+
+	// The second response does not fit and must not block
+	network.SendResponse(rpc)
+	network.SendResponse(rpc)
+	if len(network.ResponseQueue) != 1 || network.Counters.Get(kademlia.CounterResponsesDropped) != 1 {
+		t.Errorf("Expected 1 queued and 1 dropped response, got %d and %d", len(network.ResponseQueue), network.Counters.Get(kademlia.CounterResponsesDropped))
+	}
+}
+
+func TestEnqueueRequestDropNewest(t *testing.T) {
+	node := initNodeNetwork()
+	network := &kademlia.Network{Node: node, RequestQueue: make(chan *kademlia.RPC, 1), Counters: kademlia.NewCounters()}
+
+	first := &kademlia.RPC{ID: kademlia.NewRandomKademliaID()}
+	second := &kademlia.RPC{ID: kademlia.NewRandomKademliaID()}
+	network.EnqueueRequest(first)
+	network.EnqueueRequest(second)
+
+	if network.Counters.Get(kademlia.CounterRequestsQueued) != 1 {
+		t.Errorf("Expected 1 queued request, got %d", network.Counters.Get(kademlia.CounterRequestsQueued))
+	}
+	if network.Counters.Get(kademlia.CounterRequestsDropped) != 1 {
+		t.Errorf("Expected 1 dropped request, got %d", network.Counters.Get(kademlia.CounterRequestsDropped))
+	}
+	if queued := <-network.RequestQueue; !queued.ID.Equals(first.ID) {
+		t.Errorf("Expected the first request to be kept, got %v", queued.ID)
+	}
+}
+
+func TestEnqueueRequestDropOldest(t *testing.T) {
+	node := initNodeNetwork()
+	network := &kademlia.Network{Node: node, RequestQueue: make(chan *kademlia.RPC, 1), Counters: kademlia.NewCounters()}
+
+	kademlia.RequestDropPolicy = kademlia.DropOldest
+	defer func() { kademlia.RequestDropPolicy = kademlia.DropNewest }()
+
+	first := &kademlia.RPC{ID: kademlia.NewRandomKademliaID()}
+	second := &kademlia.RPC{ID: kademlia.NewRandomKademliaID()}
+	network.EnqueueRequest(first)
+	network.EnqueueRequest(second)
+
+	if network.Counters.Get(kademlia.CounterRequestsQueued) != 2 {
+		t.Errorf("Expected 2 queued requests, got %d", network.Counters.Get(kademlia.CounterRequestsQueued))
+	}
+	if network.Counters.Get(kademlia.CounterRequestsDropped) != 1 {
+		t.Errorf("Expected 1 dropped request, got %d", network.Counters.Get(kademlia.CounterRequestsDropped))
+	}
+	if queued := <-network.RequestQueue; !queued.ID.Equals(second.ID) {
+		t.Errorf("Expected the second request to be kept, got %v", queued.ID)
+	}
+}
+
+func TestRequestWorker(t *testing.T) {
+	node := initNodeNetwork()
+	network := &kademlia.Network{Node: node, RequestQueue: make(chan *kademlia.RPC, 2), Counters: kademlia.NewCounters()}
+
+	source := kademlia.NewContact(kademlia.NewRandomKademliaID(), "127.0.0.1", 8001)
+	network.EnqueueRequest(kademlia.NewRPC(kademlia.PingRequest, false, kademlia.NewRandomKademliaID(), nil, source, node.Me))
+	network.EnqueueRequest(kademlia.NewRPC(kademlia.PingRequest, false, kademlia.NewRandomKademliaID(), nil, source, node.Me))
+	close(network.RequestQueue)
+
+	network.Wg.Add(1)
+	network.RequestWorker()
+
+	if network.Counters.Get(kademlia.CounterRequestsProcessed) != 2 {
+		t.Errorf("Expected 2 processed requests, got %d", network.Counters.Get(kademlia.CounterRequestsProcessed))
+	}
+}
+
+func TestGetLocalIp(t *testing.T) {
+	if ip := kademlia.GetLocalIp("does-not-exist"); ip != "127.0.0.1" {
+		t.Errorf("Expected fallback 127.0.0.1 for unknown interface, got %s", ip)
+	}
+	// Loopback addresses are never advertised
+	if ip := kademlia.GetLocalIp("lo"); ip != "127.0.0.1" {
+		t.Errorf("Expected fallback 127.0.0.1 for loopback interface, got %s", ip)
+	}
+}
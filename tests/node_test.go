@@ -1,9 +1,11 @@
 package tests
 
 import (
+	"fmt"
 	kademlia "kadlab-group-6/pkg/kademlia_node"
 	mocks "kadlab-group-6/pkg/mocks"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -98,3 +100,89 @@ func TestLookupContact(t *testing.T) {
 	}
 
 }
+
+func TestCanReach(t *testing.T) {
+	node := initTestNode()
+	ipv4 := kademlia.NewContact(kademlia.NewRandomKademliaID(), "10.0.0.1", 8000)
+	ipv6 := kademlia.NewContact(kademlia.NewRandomKademliaID(), "fd00::1", 8000)
+	hostname := kademlia.NewContact(kademlia.NewRandomKademliaID(), "bootstrap-node", 8000)
+
+	// Without the filter all contacts are tried
+	if !node.CanReach(ipv6) {
+		t.Errorf("Expected contacts to be reachable without the filter")
+	}
+
+	kademlia.FilterUnreachable = true
+	defer func() { kademlia.FilterUnreachable = false }()
+	if !node.CanReach(ipv4) || !node.CanReach(hostname) {
+		t.Errorf("Expected IPv4 node to reach IPv4 contacts and host names")
+	}
+	if node.CanReach(ipv6) {
+		t.Errorf("Expected IPv4 node to not reach IPv6 contacts")
+	}
+
+	kademlia.DualStack = true
+	defer func() { kademlia.DualStack = false }()
+	if !node.CanReach(ipv6) {
+		t.Errorf("Expected dual stack node to reach IPv6 contacts")
+	}
+}
+
+func TestReportObservedAddress(t *testing.T) {
+	node := initTestNode()
+	node.ExternalAddress = kademlia.NewExternalAddress()
+	port := node.Me.Port
+
+	for i := 0; i < kademlia.ExternalAddressQuorum-1; i++ {
+		node.ReportObservedAddress(fmt.Sprintf("10.0.%d.1", i), "1.2.3.4")
+	}
+	if node.Me.Ip != "127.0.0.1" {
+		t.Errorf("Expected address to be unchanged before a quorum, got %s", node.Me.Ip)
+	}
+
+	node.ReportObservedAddress("10.1.0.1", "1.2.3.4")
+	if node.Me.Ip != "1.2.3.4" {
+		t.Errorf("Expected address to change to 1.2.3.4, got %s", node.Me.Ip)
+	}
+	if node.Me.Port != port {
+		t.Errorf("Expected port to be unchanged, got %d", node.Me.Port)
+	}
+}
+
+func TestReportObservedAddressConcurrentReaders(t *testing.T) {
+	node := initTestNode()
+	node.ExternalAddress = kademlia.NewExternalAddress()
+
+	// Readers see either the old or the new contact, never a partial change
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if ip := node.GetMe().Ip; ip != "127.0.0.1" && ip != "1.2.3.4" {
+					t.Errorf("Expected old or new address, got %s", ip)
+				}
+			}
+		}()
+	}
+	for i := 0; i < kademlia.ExternalAddressQuorum; i++ {
+		node.ReportObservedAddress(fmt.Sprintf("10.0.%d.1", i), "1.2.3.4")
+	}
+	wg.Wait()
+
+	if node.GetMe().Ip != "1.2.3.4" {
+		t.Errorf("Expected address to change to 1.2.3.4, got %s", node.GetMe().Ip)
+	}
+}
+
+func TestRandomIDInBucket(t *testing.T) {
+	node := initTestNode()
+	node.Me.Id = kademlia.NewRandomKademliaID()
+	for _, i := range []int{0, 1, 7, 8, 100, kademlia.IDLength*8 - 1} {
+		id := node.RandomIDInBucket(i)
+		if index := kademlia.GetBucketIndex(id, node.Me.Id); index != i {
+			t.Errorf("Expected random ID %s to be in bucket %d, got %d", id, i, index)
+		}
+	}
+}
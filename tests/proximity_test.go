@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"fmt"
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	mocks "kadlab-group-6/pkg/mocks"
+	"testing"
+	"time"
+)
+
+// delayedPingHandler answers pings after the delay configured for the destination
+type delayedPingHandler struct {
+	*mocks.MockMessageHandler
+	delays map[string]time.Duration
+}
+
+func (handler *delayedPingHandler) SendPingRequest(source *kademlia.Contact, destination *kademlia.Contact) (*kademlia.RPC, error) {
+	time.Sleep(handler.delays[destination.Id.String()])
+	return &kademlia.RPC{}, nil
+}
+
+// initProximityNode creates a node with buckets of 2 contacts 04.. and 05.. with
+// round trip times of 100ms and 10ms, and a candidate 06.. answering after 5ms
+func initProximityNode() (*kademlia.Node, *kademlia.Contact, *kademlia.Contact) {
+	node := &kademlia.Node{
+		K:  2,
+		Me: kademlia.NewContact(kademlia.NewKademliaID(fmt.Sprintf("%040d", 0)), "", 0),
+	}
+	node.RoutingTable = kademlia.NewRoutingTable(node)
+	node.MessageHandler = &delayedPingHandler{
+		mocks.NewMockMessageHandler(node),
+		map[string]time.Duration{fmt.Sprintf("%040d", 6): 5 * time.Millisecond},
+	}
+	node.Network = mocks.NewMockNetwork(node)
+
+	slow := &kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", 4))}
+	fast := &kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", 5))}
+	node.RoutingTable.AddContact(slow)
+	node.RoutingTable.AddContact(fast)
+	node.RoutingTable.RecordSuccess(slow, 100*time.Millisecond)
+	node.RoutingTable.RecordSuccess(fast, 10*time.Millisecond)
+	return node, slow, &kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", 6))}
+}
+
+func TestProximitySelectionReplacesSlowestContact(t *testing.T) {
+	kademlia.ProximitySelection = true
+	defer func() { kademlia.ProximitySelection = false }()
+
+	node, slow, candidate := initProximityNode()
+	node.RoutingTable.AddContact(candidate)
+	fixedTable(node).WaitForEvictions()
+
+	if !hasContact(node, candidate.Id.String()) || hasContact(node, slow.Id.String()) {
+		t.Errorf("Expected the candidate to replace the slowest contact")
+	}
+	// The slow contact is still live and kept as a candidate
+	replacements := fixedTable(node).Buckets[2].GetReplacements()
+	if len(replacements) != 1 || !replacements[0].Id.Equals(slow.Id) {
+		t.Errorf("Expected the slow contact in the replacement cache, got %v", replacements)
+	}
+}
+
+func TestProximitySelectionComparesAllCandidates(t *testing.T) {
+	node, slow, cached := initProximityNode()
+	node.MessageHandler.(*delayedPingHandler).delays[fmt.Sprintf("%040d", 7)] = 200 * time.Millisecond
+
+	// The fast candidate is cached before proximity selection is enabled
+	node.RoutingTable.AddContact(cached)
+	fixedTable(node).WaitForEvictions()
+
+	kademlia.ProximitySelection = true
+	defer func() { kademlia.ProximitySelection = false }()
+	// A candidate slower than all contacts still gets the cached candidate compared
+	candidate := &kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", 7))}
+	node.RoutingTable.AddContact(candidate)
+	fixedTable(node).WaitForEvictions()
+
+	if !hasContact(node, cached.Id.String()) || hasContact(node, slow.Id.String()) || hasContact(node, candidate.Id.String()) {
+		t.Errorf("Expected the fastest cached candidate to replace the slowest contact")
+	}
+}
+
+func TestProximitySelectionKeepsDiversity(t *testing.T) {
+	kademlia.ProximitySelection = true
+	kademlia.MaxSameSubnetPerBucket = 1
+	defer func() {
+		kademlia.ProximitySelection = false
+		kademlia.MaxSameSubnetPerBucket = 0
+	}()
+
+	node, slow, candidate := initProximityNode()
+	node.MessageHandler.(*delayedPingHandler).delays[candidate.Id.String()] = 50 * time.Millisecond
+	candidate.Ip = "10.0.2.1"
+	node.RoutingTable.AddContact(candidate)
+	// The fast contact moves into the network of the candidate while it is pinged
+	node.RoutingTable.AddContact(kademlia.NewContact(kademlia.NewKademliaID(fmt.Sprintf("%040d", 5)), "10.0.2.2", 8000))
+	fixedTable(node).WaitForEvictions()
+
+	if hasContact(node, candidate.Id.String()) || !hasContact(node, slow.Id.String()) {
+		t.Errorf("Expected the candidate to not exceed the contacts per network")
+	}
+}
+
+func TestWithoutProximitySelectionLiveContactsStay(t *testing.T) {
+	node, slow, candidate := initProximityNode()
+	node.RoutingTable.AddContact(candidate)
+	fixedTable(node).WaitForEvictions()
+
+	if hasContact(node, candidate.Id.String()) || !hasContact(node, slow.Id.String()) {
+		t.Errorf("Expected the live contacts to stay in the bucket")
+	}
+}
+
+func TestGetFastestContactsNotContacted(t *testing.T) {
+	shortlist := kademlia.NewShortlist(kademlia.NewKademliaID(fmt.Sprintf("%040d", 0)), 20)
+	for _, i := range []int{1, 4, 5} {
+		shortlist.AddContact(&kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", i))})
+	}
+	rtts := map[kademlia.KademliaID]time.Duration{
+		*kademlia.NewKademliaID(fmt.Sprintf("%040d", 4)): 50 * time.Millisecond,
+		*kademlia.NewKademliaID(fmt.Sprintf("%040d", 5)): time.Millisecond,
+	}
+
+	// 04.. and 05.. share as many bits with the target, so the faster one comes first
+	contacts := shortlist.GetFastestContactsNotContacted(2, map[*kademlia.KademliaID]bool{}, rtts)
+	if len(contacts) != 2 || contacts[0].Id.String() != fmt.Sprintf("%040d", 1) || contacts[1].Id.String() != fmt.Sprintf("%040d", 5) {
+		t.Errorf("Expected contacts 1 and 5, got %v", contacts)
+	}
+	// Without round trip times the order is by distance
+	contacts = shortlist.GetFastestContactsNotContacted(2, map[*kademlia.KademliaID]bool{}, nil)
+	if len(contacts) != 2 || contacts[1].Id.String() != fmt.Sprintf("%040d", 4) {
+		t.Errorf("Expected contacts 1 and 4, got %v", contacts)
+	}
+}
+
+func TestMemoryNetworkLookupRTTTieBreak(t *testing.T) {
+	kademlia.LookupRTTTieBreak = true
+	t.Cleanup(func() { kademlia.LookupRTTTieBreak = false })
+
+	hub := kademlia.NewMemoryHub()
+	nodes := initMemoryNetwork(t, hub, 20)
+
+	target := nodes[len(nodes)-1].Me
+	contacts := nodes[1].LookupContact(kademlia.NewContact(target.Id, "", 0))
+	if len(contacts) == 0 || !contacts[0].Id.Equals(target.Id) {
+		t.Errorf("Expected lookup to find %s, got %v", target.Id, contacts)
+	}
+}
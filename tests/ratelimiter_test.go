@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"fmt"
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurst(t *testing.T) {
+	limiter := kademlia.NewRateLimiter(map[kademlia.RPCType]kademlia.RateLimit{
+		kademlia.PingRequest: {Rate: 1, Burst: 3},
+	})
+	id := kademlia.NewRandomKademliaID()
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !limiter.AllowAt("10.0.0.1", id, kademlia.PingRequest, now) {
+			t.Errorf("Expected request %d within burst to be allowed", i)
+		}
+	}
+	if limiter.AllowAt("10.0.0.1", id, kademlia.PingRequest, now) {
+		t.Errorf("Expected request exceeding burst to be denied")
+	}
+
+	// Tokens are refilled over time
+	if !limiter.AllowAt("10.0.0.1", id, kademlia.PingRequest, now.Add(time.Second)) {
+		t.Errorf("Expected request after refill to be allowed")
+	}
+}
+
+func TestRateLimiterPerType(t *testing.T) {
+	limiter := kademlia.NewRateLimiter(map[kademlia.RPCType]kademlia.RateLimit{
+		kademlia.PingRequest:     {Rate: 1, Burst: 1},
+		kademlia.FindNodeRequest: {Rate: 1, Burst: 1},
+	})
+	id := kademlia.NewRandomKademliaID()
+	now := time.Now()
+
+	if !limiter.AllowAt("10.0.0.1", id, kademlia.PingRequest, now) {
+		t.Errorf("Expected ping to be allowed")
+	}
+	if !limiter.AllowAt("10.0.0.1", id, kademlia.FindNodeRequest, now) {
+		t.Errorf("Expected find node to be allowed with a separate budget")
+	}
+
+	// Types without a budget are never limited
+	for i := 0; i < 10; i++ {
+		if !limiter.AllowAt("10.0.0.1", id, kademlia.StoreRequest, now) {
+			t.Errorf("Expected store without budget to be allowed")
+		}
+	}
+}
+
+func TestRateLimiterPerSource(t *testing.T) {
+	limiter := kademlia.NewRateLimiter(map[kademlia.RPCType]kademlia.RateLimit{
+		kademlia.PingRequest: {Rate: 1, Burst: 1},
+	})
+	now := time.Now()
+
+	if !limiter.AllowAt("10.0.0.1", kademlia.NewRandomKademliaID(), kademlia.PingRequest, now) {
+		t.Errorf("Expected first request to be allowed")
+	}
+	// A new node ID from the same IP is limited by the IP budget
+	if limiter.AllowAt("10.0.0.1", kademlia.NewRandomKademliaID(), kademlia.PingRequest, now) {
+		t.Errorf("Expected request from the same IP to be denied")
+	}
+	// The same node ID from another IP is limited by the node ID budget
+	id := kademlia.NewRandomKademliaID()
+	limiter.AllowAt("10.0.0.2", id, kademlia.PingRequest, now)
+	if limiter.AllowAt("10.0.0.3", id, kademlia.PingRequest, now) {
+		t.Errorf("Expected request from the same node ID to be denied")
+	}
+	// Other sources have their own budget
+	if !limiter.AllowAt("10.0.0.4", kademlia.NewRandomKademliaID(), kademlia.PingRequest, now) {
+		t.Errorf("Expected request from another source to be allowed")
+	}
+}
+
+func TestRateLimiterBan(t *testing.T) {
+	limiter := kademlia.NewRateLimiter(map[kademlia.RPCType]kademlia.RateLimit{
+		kademlia.PingRequest: {Rate: 1, Burst: 1},
+	})
+	threshold := kademlia.BanThreshold
+	kademlia.BanThreshold = 3
+	defer func() { kademlia.BanThreshold = threshold }()
+
+	id := kademlia.NewRandomKademliaID()
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		limiter.AllowAt("10.0.0.1", id, kademlia.PingRequest, now)
+	}
+	if !limiter.IsBanned("10.0.0.1", nil) {
+		t.Fatalf("Expected source to be banned")
+	}
+
+	// Banned sources are denied even for requests without a budget
+	if limiter.AllowAt("10.0.0.1", nil, kademlia.StoreRequest, now.Add(time.Minute)) {
+		t.Errorf("Expected request from banned source to be denied")
+	}
+	// Bans expire
+	if !limiter.AllowAt("10.0.0.1", nil, kademlia.PingRequest, now.Add(kademlia.BanDuration+time.Second)) {
+		t.Errorf("Expected request after the ban to be allowed")
+	}
+}
+
+func TestRateLimiterBanWithoutID(t *testing.T) {
+	limiter := kademlia.NewRateLimiter(map[kademlia.RPCType]kademlia.RateLimit{
+		kademlia.PingRequest: {Rate: 1, Burst: 1},
+	})
+	threshold := kademlia.BanThreshold
+	kademlia.BanThreshold = 3
+	defer func() { kademlia.BanThreshold = threshold }()
+
+	// Unauthenticated requests only count against the IP
+	id := kademlia.NewRandomKademliaID()
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		limiter.AllowAt("10.0.0.1", nil, kademlia.PingRequest, now)
+	}
+	if !limiter.IsBanned("10.0.0.1", nil) {
+		t.Fatalf("Expected IP to be banned")
+	}
+	if !limiter.AllowAt("10.0.0.2", id, kademlia.PingRequest, now) || limiter.IsBanned("10.0.0.2", id) {
+		t.Errorf("Expected requests from another IP to be allowed")
+	}
+}
+
+func TestRateLimiterTakesTokensOnlyWhenAllowed(t *testing.T) {
+	limiter := kademlia.NewRateLimiter(map[kademlia.RPCType]kademlia.RateLimit{
+		kademlia.PingRequest: {Rate: 1, Burst: 1},
+	})
+	now := time.Now()
+
+	id := kademlia.NewRandomKademliaID()
+	limiter.AllowAt("10.0.0.1", id, kademlia.PingRequest, now)
+	if limiter.AllowAt("10.0.0.2", id, kademlia.PingRequest, now) {
+		t.Fatalf("Expected request from the same node ID to be denied")
+	}
+	// The denied request did not use the budget of its IP
+	if !limiter.AllowAt("10.0.0.2", nil, kademlia.PingRequest, now) {
+		t.Errorf("Expected the IP to keep its budget")
+	}
+}
+
+func TestRateLimiterMaxTrackedSources(t *testing.T) {
+	limiter := kademlia.NewRateLimiter(map[kademlia.RPCType]kademlia.RateLimit{
+		kademlia.PingRequest: {Rate: 1, Burst: 1},
+	})
+	maxSources := kademlia.MaxTrackedSources
+	kademlia.MaxTrackedSources = 3
+	defer func() { kademlia.MaxTrackedSources = maxSources }()
+
+	// None of the sources is idle, so the least recently used are dropped
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		limiter.AllowAt(fmt.Sprintf("10.0.0.%d", i), nil, kademlia.PingRequest, now)
+	}
+	if limiter.Len() != 3 {
+		t.Errorf("Expected 3 tracked sources, got %d", limiter.Len())
+	}
+	// The most recent source is still tracked and out of budget
+	if limiter.AllowAt("10.0.0.9", nil, kademlia.PingRequest, now) {
+		t.Errorf("Expected the most recent source to be limited")
+	}
+}
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"testing"
+	"time"
+)
+
+func newCacheTestRPC(rpcType kademlia.RPCType) *kademlia.RPC {
+	source := kademlia.NewContact(kademlia.NewRandomKademliaID(), "127.0.0.1", 8001)
+	destination := kademlia.NewContact(kademlia.NewRandomKademliaID(), "127.0.0.1", 8002)
+	return kademlia.NewRPC(rpcType, false, kademlia.NewRandomKademliaID(), nil, source, destination)
+}
+
+func TestResponseCacheDuplicate(t *testing.T) {
+	cache := kademlia.NewResponseCache()
+	request := newCacheTestRPC(kademlia.PingRequest)
+
+	if _, _, duplicate := cache.Begin(request); duplicate {
+		t.Errorf("Expected first request to not be a duplicate")
+	}
+
+	// Duplicates of a request in progress have no response yet
+	cached, _, duplicate := cache.Begin(request)
+	if !duplicate || cached != nil {
+		t.Errorf("Expected duplicate without response, got %v, %t", cached, duplicate)
+	}
+
+	response := kademlia.NewRPC(kademlia.PingResponse, true, request.ID, nil, request.Destination, request.Source)
+	cache.Complete(request, response, true)
+
+	cached, sent, duplicate := cache.Begin(request)
+	if !duplicate || cached != response || !sent {
+		t.Errorf("Expected duplicate with sent cached response, got %v, %t, %t", cached, sent, duplicate)
+	}
+
+	// Requests of another type with the same ID are not duplicates
+	other := *request
+	other.Type = kademlia.FindNodeRequest
+	if _, _, duplicate := cache.Begin(&other); duplicate {
+		t.Errorf("Expected request of another type to not be a duplicate")
+	}
+}
+
+func TestResponseCacheAbort(t *testing.T) {
+	cache := kademlia.NewResponseCache()
+	request := newCacheTestRPC(kademlia.PingRequest)
+
+	cache.Begin(request)
+	cache.Abort(request)
+	if _, _, duplicate := cache.Begin(request); duplicate {
+		t.Errorf("Expected aborted request to not be a duplicate")
+	}
+}
+
+func TestResponseCacheExpiry(t *testing.T) {
+	ttl := kademlia.ResponseCacheTTL
+	kademlia.ResponseCacheTTL = 10 * time.Millisecond
+	defer func() { kademlia.ResponseCacheTTL = ttl }()
+
+	cache := kademlia.NewResponseCache()
+	request := newCacheTestRPC(kademlia.PingRequest)
+	cache.Begin(request)
+
+	time.Sleep(20 * time.Millisecond)
+	if _, _, duplicate := cache.Begin(request); duplicate {
+		t.Errorf("Expected expired request to not be a duplicate")
+	}
+}
+
+func TestResponseCacheSize(t *testing.T) {
+	size := kademlia.ResponseCacheSize
+	kademlia.ResponseCacheSize = 2
+	defer func() { kademlia.ResponseCacheSize = size }()
+
+	cache := kademlia.NewResponseCache()
+	first := newCacheTestRPC(kademlia.PingRequest)
+	cache.Begin(first)
+	cache.Begin(newCacheTestRPC(kademlia.PingRequest))
+	cache.Begin(newCacheTestRPC(kademlia.PingRequest))
+
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 cached requests, got %d", cache.Len())
+	}
+	// The oldest request has been evicted
+	if _, _, duplicate := cache.Begin(first); duplicate {
+		t.Errorf("Expected evicted request to not be a duplicate")
+	}
+}
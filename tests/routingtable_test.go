@@ -1,10 +1,13 @@
 package tests
 
 import (
+	"bytes"
 	"fmt"
 	kademlia "kadlab-group-6/pkg/kademlia_node"
 	mocks "kadlab-group-6/pkg/mocks"
+	"strings"
 	"testing"
+	"time"
 )
 
 func initNodeRT() *kademlia.Node {
@@ -21,6 +24,21 @@ func initNodeRT() *kademlia.Node {
 	return node
 }
 
+// fixedTable returns the routing table with fixed buckets of the node
+func fixedTable(node *kademlia.Node) *kademlia.RoutingTable {
+	return node.RoutingTable.(*kademlia.RoutingTable)
+}
+
+// hasContact returns true if the routing table of the node contains the KademliaID
+func hasContact(node *kademlia.Node, id string) bool {
+	found := false
+	node.RoutingTable.ForEachContact(func(contact kademlia.Contact) bool {
+		found = contact.Id.Equals(kademlia.NewKademliaID(id))
+		return !found
+	})
+	return found
+}
+
 func TestNewRoutingTable(t *testing.T) {
 	node := &kademlia.Node{
 		Me: &kademlia.Contact{
@@ -34,10 +52,10 @@ func TestNewRoutingTable(t *testing.T) {
 		t.Errorf("Expected routing table to be initialized, got nil")
 	}
 	if rt.Node != node {
-		t.Errorf("Expected node to be %v, got %v", node, node.RoutingTable.Node)
+		t.Errorf("Expected node to be %v, got %v", node, rt.Node)
 	}
 	if len(rt.Buckets) != kademlia.IDLength*8 {
-		t.Errorf("Expected %d buckets, got %d", kademlia.IDLength*8, len(node.RoutingTable.Buckets))
+		t.Errorf("Expected %d buckets, got %d", kademlia.IDLength*8, len(rt.Buckets))
 	}
 }
 
@@ -50,8 +68,8 @@ func TestAddContactRT(t *testing.T) {
 
 	node.RoutingTable.AddContact(contact)
 
-	bucketIndex := node.RoutingTable.GetBucketIndex(contact.Id)
-	bucket := node.RoutingTable.Buckets[bucketIndex]
+	bucketIndex := fixedTable(node).GetBucketIndex(contact.Id)
+	bucket := fixedTable(node).Buckets[bucketIndex]
 
 	contacts := bucket.GetContactsAndCalcDistance(node.Me.Id)
 	found := false
@@ -85,22 +103,29 @@ func TestAddContactToFullBucketPingOK(t *testing.T) {
 		counter++
 	}
 
+	fixedTable(node).WaitForEvictions()
 	t.Logf("Tried adding %d contacts to bucket 6", counter)
-	node.RoutingTable.Buckets[6].PrintBucket()
+	fixedTable(node).Buckets[6].PrintBucket()
 
-	if node.RoutingTable.Buckets[6].Len() != 20 {
-		t.Errorf("Expected bucket 6 to be full (20), got %d", node.RoutingTable.Buckets[6].Len())
+	if fixedTable(node).Buckets[6].Len() != 20 {
+		t.Errorf("Expected bucket 6 to be full (20), got %d", fixedTable(node).Buckets[6].Len())
 	}
 
 	// Expects the contacts with IDs 60 to not be in the bucket
 	contact60 := kademlia.Contact{Id: kademlia.NewKademliaID("0000000000000000000000000000000000000060")}
-	if node.RoutingTable.Buckets[6].Contains(contact60) {
+	if fixedTable(node).Buckets[6].Contains(contact60) {
 		t.Errorf("Expected contact %v to not be in bucket", contact60)
 	}
 
+	// Expects the contact with ID 60 to be kept as a replacement
+	replacements := fixedTable(node).Buckets[6].GetReplacements()
+	if len(replacements) != 1 || !replacements[0].Id.Equals(contact60.Id) {
+		t.Errorf("Expected contact %v to be the only replacement, got %v", contact60, replacements)
+	}
+
 	// Expects the contacts with IDs 41 to the least recently seen contact
 	contact41 := kademlia.Contact{Id: kademlia.NewKademliaID("0000000000000000000000000000000000000041")}
-	if !node.RoutingTable.Buckets[6].GetLeastRecentlySeenContact().Id.Equals(contact41.Id) {
+	if !fixedTable(node).Buckets[6].GetLeastRecentlySeenContact().Id.Equals(contact41.Id) {
 		t.Errorf("Expected contact %v to be least recently seen contact", contact41)
 	}
 }
@@ -124,32 +149,364 @@ func TestAddContactToFullBucketPingError(t *testing.T) {
 		counter++
 	}
 
+	fixedTable(node).WaitForEvictions()
 	t.Logf("Tried adding %d contacts to bucket 6", counter)
-	node.RoutingTable.Buckets[6].PrintBucket()
+	fixedTable(node).Buckets[6].PrintBucket()
 
-	if node.RoutingTable.Buckets[6].Len() != 20 {
-		t.Errorf("Expected bucket 6 to be full (20), got %d", node.RoutingTable.Buckets[6].Len())
+	if fixedTable(node).Buckets[6].Len() != 20 {
+		t.Errorf("Expected bucket 6 to be full (20), got %d", fixedTable(node).Buckets[6].Len())
 	}
 
 	// Expects the contacts with IDs 60 to be in the bucket
 	contact60 := kademlia.Contact{Id: kademlia.NewKademliaID("0000000000000000000000000000000000000060")}
-	if !node.RoutingTable.Buckets[6].Contains(contact60) {
+	if !fixedTable(node).Buckets[6].Contains(contact60) {
 		t.Errorf("Expected contact %v to not be in bucket", contact60)
 	}
 
 	// Expects the contact with ID 40 to not be in the bucket
 	contact40 := kademlia.Contact{Id: kademlia.NewKademliaID("0000000000000000000000000000000000000040")}
-	if node.RoutingTable.Buckets[6].Contains(contact40) {
+	if fixedTable(node).Buckets[6].Contains(contact40) {
 		t.Errorf("Expected contact %v to not be in bucket", contact40)
 	}
 
 	// Expects the contacts with IDs 41 to the least recently seen contact
 	contact41 := kademlia.Contact{Id: kademlia.NewKademliaID("0000000000000000000000000000000000000041")}
-	if !node.RoutingTable.Buckets[6].GetLeastRecentlySeenContact().Id.Equals(contact41.Id) {
+	if !fixedTable(node).Buckets[6].GetLeastRecentlySeenContact().Id.Equals(contact41.Id) {
 		t.Errorf("Expected contact %v to be least recently seen contact", contact41)
 	}
 }
 
+// TestRemoveContactPromotesReplacement tests that a failed contact is replaced
+// by the most recently seen candidate of the replacement cache
+func TestRemoveContactPromotesReplacement(t *testing.T) {
+	node := initNodeRT()
+	node.MessageHandler = mocks.NewMockMessageHandler(node)
+
+	for i := 40; i < 62; i++ {
+		contact := &kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", i))}
+		node.RoutingTable.AddContact(contact)
+	}
+	fixedTable(node).WaitForEvictions()
+
+	contact45 := &kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", 45))}
+	contact61 := kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", 61))}
+	node.RoutingTable.RemoveContact(contact45)
+
+	bucket := fixedTable(node).Buckets[6]
+	if bucket.Contains(*contact45) {
+		t.Errorf("Expected contact %v to be removed", contact45)
+	}
+	if !bucket.Contains(contact61) {
+		t.Errorf("Expected most recent replacement %v to be promoted", contact61)
+	}
+	if bucket.Len() != 20 {
+		t.Errorf("Expected bucket 6 to be full (20), got %d", bucket.Len())
+	}
+	if len(bucket.GetReplacements()) != 1 {
+		t.Errorf("Expected 1 replacement left, got %d", len(bucket.GetReplacements()))
+	}
+}
+
+// blockingPingHandler answers pings only after release is closed
+type blockingPingHandler struct {
+	*mocks.MockMessageHandler
+	release chan struct{}
+}
+
+func (handler *blockingPingHandler) SendPingRequest(source *kademlia.Contact, destination *kademlia.Contact) (*kademlia.RPC, error) {
+	<-handler.release
+	return nil, fmt.Errorf("timeout")
+}
+
+// TestAddContactToFullBucketDoesNotBlock tests that the routing table stays
+// usable while the least recently seen contact of a full bucket is pinged
+func TestAddContactToFullBucketDoesNotBlock(t *testing.T) {
+	node := initNodeRT()
+	handler := &blockingPingHandler{mocks.NewMockMessageHandler(node), make(chan struct{})}
+	node.MessageHandler = handler
+
+	done := make(chan struct{})
+	go func() {
+		for i := 40; i < 62; i++ {
+			contact := &kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", i))}
+			node.RoutingTable.AddContact(contact)
+		}
+		node.RoutingTable.FindClosestContacts(node.Me.Id)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected AddContact and FindClosestContacts to not wait for the ping")
+	}
+
+	bucket := fixedTable(node).Buckets[6]
+	if len(bucket.GetReplacements()) != 2 {
+		t.Errorf("Expected 2 queued candidates, got %d", len(bucket.GetReplacements()))
+	}
+
+	close(handler.release)
+	fixedTable(node).WaitForEvictions()
+
+	// Only one ping is sent per bucket, so one candidate is promoted
+	contact40 := kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", 40))}
+	contact61 := kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", 61))}
+	if bucket.Contains(contact40) {
+		t.Errorf("Expected contact %v to be evicted", contact40)
+	}
+	if !bucket.Contains(contact61) {
+		t.Errorf("Expected most recent candidate %v to be promoted", contact61)
+	}
+}
+
+func TestGetRefreshTargets(t *testing.T) {
+	node := initNodeRT()
+	created := time.Now()
+
+	if targets := node.RoutingTable.GetRefreshTargets(created.Add(-time.Hour)); len(targets) != 0 {
+		t.Errorf("Expected no stale buckets after creation, got %v", targets)
+	}
+
+	time.Sleep(time.Millisecond)
+	since := time.Now()
+	contact := &kademlia.Contact{Id: kademlia.NewKademliaID("0000000000000000000000000000000000000002")}
+	node.RoutingTable.AddContact(contact)
+	node.RoutingTable.Touch(kademlia.NewKademliaID("8000000000000000000000000000000000000000"))
+
+	targets := node.RoutingTable.GetRefreshTargets(since)
+	if len(targets) != kademlia.IDLength*8-2 {
+		t.Errorf("Expected %d stale buckets, got %d", kademlia.IDLength*8-2, len(targets))
+	}
+	// Every stale bucket gets one target in its range
+	seen := make(map[int]bool)
+	for _, target := range targets {
+		i := fixedTable(node).GetBucketIndex(target)
+		if i == 1 || i == kademlia.IDLength*8-1 {
+			t.Errorf("Expected bucket %d with activity to not be stale", i)
+		}
+		if seen[i] {
+			t.Errorf("Expected one target in bucket %d", i)
+		}
+		seen[i] = true
+	}
+}
+
+func TestGetBucketStats(t *testing.T) {
+	node := initNodeRT()
+	node.Me.Id = kademlia.NewKademliaID("a000000000000000000000000000000000000000")
+	node.RoutingTable.AddContact(&kademlia.Contact{Id: kademlia.NewKademliaID("2000000000000000000000000000000000000000")})
+	node.RoutingTable.AddContact(&kademlia.Contact{Id: kademlia.NewKademliaID("8000000000000000000000000000000000000000")})
+
+	stats := node.RoutingTable.GetBucketStats()
+	if len(stats) != kademlia.IDLength*8 {
+		t.Fatalf("Expected %d buckets, got %d", kademlia.IDLength*8, len(stats))
+	}
+	last := stats[kademlia.IDLength*8-1]
+	if last.Prefix != "0" || last.Contacts != 1 {
+		t.Errorf("Expected bucket with prefix 0 and 1 contact, got %v", last)
+	}
+	third := stats[kademlia.IDLength*8-3]
+	if third.Prefix != "100" || third.Contacts != 1 {
+		t.Errorf("Expected bucket with prefix 100 and 1 contact, got %v", third)
+	}
+}
+
+func TestForEachContact(t *testing.T) {
+	node := initNodeRT()
+	for i := 1; i <= 5; i++ {
+		node.RoutingTable.AddContact(&kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", i))})
+	}
+
+	count := 0
+	node.RoutingTable.ForEachContact(func(contact kademlia.Contact) bool {
+		// The routing table can be used while iterating
+		node.RoutingTable.Touch(contact.Id)
+		count++
+		return true
+	})
+	if count != 5 {
+		t.Errorf("Expected 5 contacts, got %d", count)
+	}
+
+	count = 0
+	node.RoutingTable.ForEachContact(func(contact kademlia.Contact) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("Expected iteration to stop after 2 contacts, got %d", count)
+	}
+}
+
+// TestRecordFailureEvictsContact tests that a contact is evicted after
+// FailureThreshold consecutive failures and replaced by a candidate
+func TestRecordFailureEvictsContact(t *testing.T) {
+	node := initNodeRT()
+	node.MessageHandler = mocks.NewMockMessageHandler(node)
+	for i := 40; i < 61; i++ {
+		node.RoutingTable.AddContact(&kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", i))})
+	}
+	fixedTable(node).WaitForEvictions()
+
+	contact45 := &kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", 45))}
+	for i := 0; i < kademlia.FailureThreshold-1; i++ {
+		node.RoutingTable.RecordFailure(contact45)
+	}
+	// A successful exchange clears the failures
+	node.RoutingTable.RecordSuccess(contact45, 0)
+	for i := 0; i < kademlia.FailureThreshold-1; i++ {
+		node.RoutingTable.RecordFailure(contact45)
+	}
+	if !hasContact(node, contact45.Id.String()) {
+		t.Fatalf("Expected contact %v to be kept below the failure threshold", contact45)
+	}
+
+	node.RoutingTable.RecordFailure(contact45)
+	if hasContact(node, contact45.Id.String()) {
+		t.Errorf("Expected contact %v to be evicted at the failure threshold", contact45)
+	}
+	if !hasContact(node, fmt.Sprintf("%040d", 60)) {
+		t.Errorf("Expected the replacement to be promoted")
+	}
+}
+
+// TestAdvertisedContactKeepsFailures tests that a dead contact still advertised
+// by other nodes is not refreshed and reaches the FailureThreshold
+func TestAdvertisedContactKeepsFailures(t *testing.T) {
+	node := initNodeRT()
+	contact := kademlia.NewContact(kademlia.NewKademliaID(fmt.Sprintf("%040d", 45)), "10.0.0.1", 8000)
+	node.RoutingTable.AddContact(contact)
+
+	for i := 0; i < kademlia.FailureThreshold-1; i++ {
+		node.RoutingTable.RecordFailure(contact)
+		node.RoutingTable.UpdateRoutingTable([]*kademlia.Contact{kademlia.NewContact(contact.Id, "10.0.0.2", 8000)})
+	}
+	stats := node.RoutingTable.GetContactStats()
+	if len(stats) != 1 || stats[0].Failures != kademlia.FailureThreshold-1 || stats[0].Address != "10.0.0.1:8000" {
+		t.Fatalf("Expected the advertised contact to be left as it is, got %+v", stats)
+	}
+
+	node.RoutingTable.RecordFailure(contact)
+	if hasContact(node, contact.Id.String()) {
+		t.Errorf("Expected contact %v to be evicted at the failure threshold", contact)
+	}
+}
+
+// TestAdvertisedContactNotSeen tests that only contacts we exchanged messages with
+// count as seen and that the stats logged for operators show it
+func TestAdvertisedContactNotSeen(t *testing.T) {
+	node := initNodeRT()
+	seen := kademlia.NewContact(kademlia.NewKademliaID(fmt.Sprintf("%040d", 45)), "10.0.0.1", 8000)
+	advertised := kademlia.NewContact(kademlia.NewKademliaID(fmt.Sprintf("%040d", 46)), "10.0.0.2", 8000)
+	node.RoutingTable.AddContact(seen)
+	node.RoutingTable.UpdateRoutingTable([]*kademlia.Contact{advertised})
+
+	for _, contact := range node.RoutingTable.GetContactStats() {
+		if contact.Id == seen.Id.String() && contact.LastSeen.IsZero() {
+			t.Errorf("Expected contact %s to be seen", contact.Id)
+		}
+		if contact.Id == advertised.Id.String() && !contact.LastSeen.IsZero() {
+			t.Errorf("Expected advertised contact %s to not be seen, got %v", contact.Id, contact.LastSeen)
+		}
+	}
+	if stats := node.RoutingTableStats(); !strings.Contains(stats, "contact "+advertised.Id.String()+" at 10.0.0.2:8000: last seen never") {
+		t.Errorf("Expected the stats to show the advertised contact as never seen, got %s", stats)
+	}
+}
+
+func TestSubnetDiversityPerBucket(t *testing.T) {
+	kademlia.MaxSameIPPerBucket = 1
+	kademlia.MaxSameSubnetPerBucket = 2
+	defer func() {
+		kademlia.MaxSameIPPerBucket = 0
+		kademlia.MaxSameSubnetPerBucket = 0
+	}()
+	node := initNodeRT()
+
+	// All contacts fall into bucket 6
+	addresses := []string{"10.0.0.1", "10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.1.1", "bootstrap-node", "bootstrap-node"}
+	for i, address := range addresses {
+		node.RoutingTable.AddContact(kademlia.NewContact(kademlia.NewKademliaID(fmt.Sprintf("%040d", 40+i)), address, 8000))
+	}
+
+	// The second contact on 10.0.0.1 and the third in 10.0.0.0/24 are rejected
+	expected := []int{40, 42, 44, 45, 46}
+	if fixedTable(node).Buckets[6].Len() != len(expected) {
+		t.Errorf("Expected %d contacts in bucket 6, got %d", len(expected), fixedTable(node).Buckets[6].Len())
+	}
+	for _, i := range expected {
+		if !hasContact(node, fmt.Sprintf("%040d", i)) {
+			t.Errorf("Expected contact %d to be added", i)
+		}
+	}
+	counters := fixedTable(node).Counters
+	if counters.Get(kademlia.CounterSameIPRejected) != 1 || counters.Get(kademlia.CounterSameSubnetRejected) != 1 {
+		t.Errorf("Expected 1 rejection of each kind, got %v", counters.Snapshot())
+	}
+
+	// Contacts already in the table are still updated
+	node.RoutingTable.AddContact(kademlia.NewContact(kademlia.NewKademliaID(fmt.Sprintf("%040d", 40)), "10.0.0.1", 8000))
+	if counters.Get(kademlia.CounterSameIPRejected) != 1 {
+		t.Errorf("Expected known contact to not be rejected")
+	}
+}
+
+func TestPromoteReplacementKeepsDiversity(t *testing.T) {
+	kademlia.MaxSameSubnetPerBucket = 1
+	defer func() { kademlia.MaxSameSubnetPerBucket = 0 }()
+	node := initNodeRT()
+	node.MessageHandler = mocks.NewMockMessageHandler(node)
+	for i := 40; i < 60; i++ {
+		node.RoutingTable.AddContact(kademlia.NewContact(kademlia.NewKademliaID(fmt.Sprintf("%040d", i)), fmt.Sprintf("10.0.%d.1", i), 8000))
+	}
+	// Both candidates are queued, 60 is the most recently seen one
+	node.RoutingTable.AddContact(kademlia.NewContact(kademlia.NewKademliaID(fmt.Sprintf("%040d", 61)), "10.1.1.1", 8000))
+	node.RoutingTable.AddContact(kademlia.NewContact(kademlia.NewKademliaID(fmt.Sprintf("%040d", 60)), "10.1.0.1", 8000))
+	fixedTable(node).WaitForEvictions()
+
+	// A contact moves into the network of candidate 60 before a contact fails
+	node.RoutingTable.AddContact(kademlia.NewContact(kademlia.NewKademliaID(fmt.Sprintf("%040d", 41)), "10.1.0.2", 8000))
+	node.RoutingTable.RemoveContact(&kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", 45))})
+
+	if hasContact(node, fmt.Sprintf("%040d", 60)) {
+		t.Errorf("Expected candidate 60 to not exceed the contacts per network")
+	}
+	if !hasContact(node, fmt.Sprintf("%040d", 61)) {
+		t.Errorf("Expected candidate 61 to be promoted instead")
+	}
+}
+
+func TestSubnetDiversityPerTable(t *testing.T) {
+	kademlia.MaxSameSubnetPerTable = 2
+	defer func() { kademlia.MaxSameSubnetPerTable = 0 }()
+	node := initNodeRT()
+
+	// The contacts fall into different buckets
+	ids := []string{
+		"0000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000002",
+		"0000000000000000000000000000000000000004",
+		"8000000000000000000000000000000000000000",
+	}
+	addresses := []string{"fd00::1", "fd00::2", "fd00::3", "fd00:0:0:1::1"}
+	for i, id := range ids {
+		node.RoutingTable.AddContact(kademlia.NewContact(kademlia.NewKademliaID(id), addresses[i], 8000))
+	}
+
+	if hasContact(node, ids[2]) {
+		t.Errorf("Expected third contact in fd00::/64 to be rejected")
+	}
+	for _, id := range []string{ids[0], ids[1], ids[3]} {
+		if !hasContact(node, id) {
+			t.Errorf("Expected contact %s to be added", id)
+		}
+	}
+	if rejected := fixedTable(node).Counters.Get(kademlia.CounterSameSubnetRejected); rejected != 1 {
+		t.Errorf("Expected 1 rejection, got %d", rejected)
+	}
+}
+
 func TestFindClosestContacts(t *testing.T) {
 	node := initNodeRT()
 
@@ -191,10 +548,10 @@ func TestUpdateRoutingTable(t *testing.T) {
 	contacts := []*kademlia.Contact{contact1, contact2}
 	node.RoutingTable.UpdateRoutingTable(contacts)
 
-	bucketIndex1 := node.RoutingTable.GetBucketIndex(contact1.Id)
-	bucket1 := node.RoutingTable.Buckets[bucketIndex1]
-	bucketIndex2 := node.RoutingTable.GetBucketIndex(contact2.Id)
-	bucket2 := node.RoutingTable.Buckets[bucketIndex2]
+	bucketIndex1 := fixedTable(node).GetBucketIndex(contact1.Id)
+	bucket1 := fixedTable(node).Buckets[bucketIndex1]
+	bucketIndex2 := fixedTable(node).GetBucketIndex(contact2.Id)
+	bucket2 := fixedTable(node).Buckets[bucketIndex2]
 
 	contacts1 := bucket1.GetContactsAndCalcDistance(node.Me.Id)
 	found1 := false
@@ -238,9 +595,135 @@ func TestGetBucketIndex(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		index := node.RoutingTable.GetBucketIndex(test.id)
+		index := fixedTable(node).GetBucketIndex(test.id)
 		if index != test.expected {
 			t.Errorf("GetBucketIndex(%v) = %d; want %d", test.id, index, test.expected)
 		}
 	}
 }
+
+func TestContactLiveness(t *testing.T) {
+	node := initNodeRT()
+	contact := kademlia.NewContact(kademlia.NewKademliaID("1111111111111111111111111111111111111111"), "10.0.0.1", 8000)
+	node.RoutingTable.AddContact(contact)
+	first := node.RoutingTable.GetContactStats()
+	if len(first) != 1 || first[0].FirstSeen.IsZero() || !first[0].LastSeen.Equal(first[0].FirstSeen) || first[0].Address != "10.0.0.1:8000" {
+		t.Fatalf("Expected a contact seen once, got %v", first)
+	}
+	// The routing table keeps its own copy of the contact
+	if !contact.FirstSeen.IsZero() || !contact.LastSeen.IsZero() || contact.Distance != nil {
+		t.Errorf("Expected the added contact to be unchanged, got %+v", contact)
+	}
+
+	node.RoutingTable.RecordSuccess(contact, 80*time.Millisecond)
+	node.RoutingTable.RecordSuccess(contact, 40*time.Millisecond)
+	node.RoutingTable.RecordFailure(contact)
+	stats := node.RoutingTable.GetContactStats()[0]
+	if stats.LastRTT != 40*time.Millisecond || stats.SmoothedRTT != 75*time.Millisecond || stats.Failures != 1 {
+		t.Errorf("Expected RTTs of 40ms and 75ms and 1 failure, got %+v", stats)
+	}
+
+	// A request from the contact marks it as seen but keeps when it was first seen
+	time.Sleep(time.Millisecond)
+	node.RoutingTable.AddContact(contact)
+	stats = node.RoutingTable.GetContactStats()[0]
+	if !stats.FirstSeen.Equal(first[0].FirstSeen) || !stats.LastSeen.After(first[0].LastSeen) || stats.Failures != 0 {
+		t.Errorf("Expected the contact to be seen again without failures, got %+v", stats)
+	}
+}
+
+func TestAddContactUpdatesAddress(t *testing.T) {
+	node := initNodeRT()
+	id := kademlia.NewKademliaID("1111111111111111111111111111111111111111")
+	node.RoutingTable.AddContact(kademlia.NewContact(id, "10.0.0.1", 8000))
+	node.RoutingTable.RecordSuccess(&kademlia.Contact{Id: id}, 20*time.Millisecond)
+	first := node.RoutingTable.GetContactStats()[0]
+
+	// The contact moved to another address and rotated its key
+	moved := kademlia.NewContact(id, "10.0.0.2", 9000)
+	moved.PublicKey = []byte{1, 2, 3}
+	node.RoutingTable.AddContact(moved)
+
+	stats := node.RoutingTable.GetContactStats()
+	if len(stats) != 1 || stats[0].Address != "10.0.0.2:9000" {
+		t.Fatalf("Expected the contact at its new address, got %v", stats)
+	}
+	if !stats[0].FirstSeen.Equal(first.FirstSeen) || stats[0].SmoothedRTT != 20*time.Millisecond {
+		t.Errorf("Expected the liveness of the contact to be kept, got %+v", stats[0])
+	}
+	contacts := node.RoutingTable.FindClosestContacts(id)
+	if len(contacts) != 1 || len(contacts[0].PublicKey) != 3 {
+		t.Errorf("Expected the new public key of the contact, got %v", contacts)
+	}
+}
+
+func TestForEachContactCopiesContacts(t *testing.T) {
+	node := initNodeRT()
+	contact := kademlia.NewContact(kademlia.NewKademliaID("1111111111111111111111111111111111111111"), "10.0.0.1", 8000)
+	contact.PublicKey = []byte{1, 2, 3}
+	node.RoutingTable.AddContact(contact)
+
+	// Changing the returned contacts does not change the routing table
+	node.RoutingTable.ForEachContact(func(contact kademlia.Contact) bool {
+		contact.Id[0] = 0xFF
+		contact.PublicKey[0] = 0xFF
+		return true
+	})
+	for _, found := range node.RoutingTable.FindClosestContactsFiltered(contact.Id, 0, nil) {
+		found.Id[1] = 0xFF
+		found.PublicKey[1] = 0xFF
+	}
+
+	id := kademlia.NewKademliaID("1111111111111111111111111111111111111111")
+	contacts := node.RoutingTable.FindClosestContacts(id)
+	if len(contacts) != 1 || !contacts[0].Id.Equals(id) {
+		t.Fatalf("Expected contact %s to be unchanged, got %v", id, contacts)
+	}
+	if !bytes.Equal(contacts[0].PublicKey, []byte{1, 2, 3}) {
+		t.Errorf("Expected the public key to be unchanged, got %v", contacts[0].PublicKey)
+	}
+}
+
+func TestFindClosestContactsFiltered(t *testing.T) {
+	node := initNodeRT()
+	for i := 1; i <= 5; i++ {
+		node.RoutingTable.AddContact(&kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", i))})
+	}
+	target := kademlia.NewKademliaID(fmt.Sprintf("%040d", 0))
+	excluded := kademlia.NewKademliaID(fmt.Sprintf("%040d", 1))
+
+	contacts := node.RoutingTable.FindClosestContactsFiltered(target, 2, kademlia.ExcludeIDs(excluded))
+	if len(contacts) != 2 || contacts[0].Id.String() != fmt.Sprintf("%040d", 2) || contacts[1].Id.String() != fmt.Sprintf("%040d", 3) {
+		t.Errorf("Expected contacts 2 and 3, got %v", contacts)
+	}
+	if all := node.RoutingTable.FindClosestContactsFiltered(target, 0, nil); len(all) != 5 {
+		t.Errorf("Expected all 5 contacts, got %d", len(all))
+	}
+	future := kademlia.SeenSince(time.Now().Add(time.Hour))
+	if recent := node.RoutingTable.FindClosestContactsFiltered(target, 0, kademlia.AllOf(kademlia.ExcludeIDs(excluded), future)); len(recent) != 0 {
+		t.Errorf("Expected no contacts seen in the future, got %v", recent)
+	}
+
+	// The contacts are copies
+	contacts[0].Port = 1234
+	if again := node.RoutingTable.FindClosestContactsFiltered(target, 1, kademlia.ExcludeIDs(excluded)); again[0].Port == 1234 {
+		t.Errorf("Expected the routing table to be unchanged by callers")
+	}
+}
+
+func TestSizeAndBucketCounts(t *testing.T) {
+	node := initNodeRT()
+	if node.RoutingTable.Size() != 0 {
+		t.Errorf("Expected an empty routing table, got %d contacts", node.RoutingTable.Size())
+	}
+	for i := 1; i <= 3; i++ {
+		node.RoutingTable.AddContact(&kademlia.Contact{Id: kademlia.NewKademliaID(fmt.Sprintf("%040d", i))})
+	}
+	if node.RoutingTable.Size() != 3 {
+		t.Errorf("Expected 3 contacts, got %d", node.RoutingTable.Size())
+	}
+	counts := node.RoutingTable.GetBucketCounts()
+	if len(counts) != kademlia.IDLength*8 || counts[0] != 1 || counts[1] != 2 {
+		t.Errorf("Expected 1 and 2 contacts in the buckets 0 and 1, got %v", counts[:2])
+	}
+}
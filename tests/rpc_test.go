@@ -100,3 +100,34 @@ func TestPayloadString(t *testing.T) {
 		t.Errorf("Expected %s, got %s", expectedString, payload.String())
 	}
 }
+
+func TestValidateHeader(t *testing.T) {
+	id := node.NewKademliaID("0000000000000000000000000000000000000000")
+	source := node.NewContact(node.NewKademliaID("1000000000000000000000000000000000000000"), "1.2.3.4", 1234)
+	destination := node.NewContact(node.NewKademliaID("2000000000000000000000000000000000000000"), "5.6.7.8", 5678)
+
+	rpc := node.NewRPC(node.PingRequest, false, id, nil, source, destination)
+	if err := node.ValidateHeader(rpc); err != nil {
+		t.Errorf("Expected valid header, got %v", err)
+	}
+
+	rpc.Header = &node.Header{Version: node.ProtocolVersion + 1, NetworkID: node.NetworkID, IDLength: node.IDLength}
+	if err := node.ValidateHeader(rpc); err == nil {
+		t.Errorf("Expected protocol version mismatch to be rejected")
+	}
+
+	rpc.Header = &node.Header{Version: node.ProtocolVersion, NetworkID: "other-network", IDLength: node.IDLength}
+	if err := node.ValidateHeader(rpc); err == nil {
+		t.Errorf("Expected network ID mismatch to be rejected")
+	}
+
+	rpc.Header = &node.Header{Version: node.ProtocolVersion, NetworkID: node.NetworkID, IDLength: node.IDLength + 1}
+	if err := node.ValidateHeader(rpc); err == nil {
+		t.Errorf("Expected ID length mismatch to be rejected")
+	}
+
+	rpc.Header = nil
+	if err := node.ValidateHeader(rpc); err == nil {
+		t.Errorf("Expected missing header to be rejected")
+	}
+}
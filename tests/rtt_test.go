@@ -0,0 +1,139 @@
+package tests
+
+import (
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"testing"
+	"time"
+)
+
+func TestRTTStatsUpdate(t *testing.T) {
+	stats := &kademlia.RTTStats{}
+
+	stats.Update(100 * time.Millisecond)
+	if stats.SmoothedRTT != 100*time.Millisecond {
+		t.Errorf("Expected smoothed RTT 100ms, got %v", stats.SmoothedRTT)
+	}
+	if stats.RTTVariance != 50*time.Millisecond {
+		t.Errorf("Expected RTT variance 50ms, got %v", stats.RTTVariance)
+	}
+
+	stats.Update(200 * time.Millisecond)
+	if stats.SmoothedRTT != 112500*time.Microsecond {
+		t.Errorf("Expected smoothed RTT 112.5ms, got %v", stats.SmoothedRTT)
+	}
+	if stats.RTTVariance != 62500*time.Microsecond {
+		t.Errorf("Expected RTT variance 62.5ms, got %v", stats.RTTVariance)
+	}
+	if stats.LastRTT != 200*time.Millisecond {
+		t.Errorf("Expected last RTT 200ms, got %v", stats.LastRTT)
+	}
+	if stats.Samples != 2 {
+		t.Errorf("Expected 2 samples, got %d", stats.Samples)
+	}
+}
+
+func TestRTTStatsTimeout(t *testing.T) {
+	stats := &kademlia.RTTStats{}
+	if stats.Timeout() != kademlia.Timeout {
+		t.Errorf("Expected default timeout %v without samples, got %v", kademlia.Timeout, stats.Timeout())
+	}
+
+	stats.Update(100 * time.Millisecond)
+	if stats.Timeout() != 300*time.Millisecond {
+		t.Errorf("Expected timeout 300ms, got %v", stats.Timeout())
+	}
+
+	// The timeout is clamped to the bounds
+	stats = &kademlia.RTTStats{}
+	stats.Update(time.Millisecond)
+	if stats.Timeout() != kademlia.MinTimeout {
+		t.Errorf("Expected minimum timeout %v, got %v", kademlia.MinTimeout, stats.Timeout())
+	}
+	stats = &kademlia.RTTStats{}
+	stats.Update(time.Minute)
+	if stats.Timeout() != kademlia.MaxTimeout {
+		t.Errorf("Expected maximum timeout %v, got %v", kademlia.MaxTimeout, stats.Timeout())
+	}
+}
+
+func TestRTTStatsBackoff(t *testing.T) {
+	stats := &kademlia.RTTStats{}
+	stats.RecordTimeout()
+	if stats.Timeout() != 2*kademlia.Timeout {
+		t.Errorf("Expected timeout %v after a timeout without samples, got %v", 2*kademlia.Timeout, stats.Timeout())
+	}
+
+	stats.Update(100 * time.Millisecond)
+	stats.RecordTimeout()
+	stats.RecordTimeout()
+	if stats.Timeout() != 1200*time.Millisecond {
+		t.Errorf("Expected timeout 1.2s after 2 timeouts, got %v", stats.Timeout())
+	}
+	for i := 0; i < 10; i++ {
+		stats.RecordTimeout()
+	}
+	if stats.Timeout() != kademlia.MaxTimeout {
+		t.Errorf("Expected timeout to be capped at %v, got %v", kademlia.MaxTimeout, stats.Timeout())
+	}
+
+	// A new sample ends the backoff
+	stats.Update(100 * time.Millisecond)
+	if stats.Backoff != 0 || stats.Timeout() >= time.Second {
+		t.Errorf("Expected the backoff to end with a new sample, got %+v", stats)
+	}
+
+	// The timeout grows by the BackoffFactor
+	factor := kademlia.BackoffFactor
+	kademlia.BackoffFactor = 3
+	defer func() { kademlia.BackoffFactor = factor }()
+	timeout := stats.Timeout()
+	stats.RecordTimeout()
+	if stats.Timeout() != 3*timeout {
+		t.Errorf("Expected timeout %v, got %v", 3*timeout, stats.Timeout())
+	}
+}
+
+func TestRetryTimeout(t *testing.T) {
+	timeout := time.Second
+	if kademlia.RetryTimeout(timeout, 0) != time.Second {
+		t.Errorf("Expected timeout 1s for the first attempt, got %v", kademlia.RetryTimeout(timeout, 0))
+	}
+	if kademlia.RetryTimeout(timeout, 2) != 4*time.Second {
+		t.Errorf("Expected timeout 4s for the third attempt, got %v", kademlia.RetryTimeout(timeout, 2))
+	}
+	if kademlia.RetryTimeout(timeout, 10) != kademlia.MaxTimeout {
+		t.Errorf("Expected timeout to be capped at %v, got %v", kademlia.MaxTimeout, kademlia.RetryTimeout(timeout, 10))
+	}
+}
+
+func TestRTTEstimator(t *testing.T) {
+	estimator := kademlia.NewRTTEstimator()
+	id := kademlia.NewRandomKademliaID()
+
+	if _, exists := estimator.GetStats(id); exists {
+		t.Errorf("Expected no statistics for unknown contact")
+	}
+	if estimator.Timeout(id) != kademlia.Timeout {
+		t.Errorf("Expected default timeout %v for unknown contact, got %v", kademlia.Timeout, estimator.Timeout(id))
+	}
+
+	estimator.Update(id, 100*time.Millisecond)
+	estimator.RecordTimeout(id)
+
+	stats, exists := estimator.GetStats(id)
+	if !exists {
+		t.Fatalf("Expected statistics for contact")
+	}
+	if stats.Samples != 1 || stats.Timeouts != 1 {
+		t.Errorf("Expected 1 sample and 1 timeout, got %d and %d", stats.Samples, stats.Timeouts)
+	}
+	// The timeout is backed off until the next sample
+	if estimator.Timeout(id) != 600*time.Millisecond {
+		t.Errorf("Expected backed off timeout 600ms, got %v", estimator.Timeout(id))
+	}
+
+	snapshot := estimator.Snapshot()
+	if snapshot[id.String()].Samples != 1 {
+		t.Errorf("Expected snapshot to contain the contact statistics, got %v", snapshot)
+	}
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoadRoutingTableSnapshot(t *testing.T) {
+	node := initNodeRT()
+	contact := kademlia.NewContact(kademlia.NewKademliaID("0000000000000000000000000000000000000002"), "10.0.0.1", 8000)
+	contact.PublicKey = []byte{1, 2, 3}
+	node.RoutingTable.AddContact(contact)
+	node.RoutingTable.RecordSuccess(contact, 20*time.Millisecond)
+	node.RoutingTable.RecordFailure(contact)
+
+	path := filepath.Join(t.TempDir(), "routing_table.json")
+	if err := node.SaveRoutingTable(path); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	snapshot, err := kademlia.LoadRoutingTableSnapshot(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !snapshot.Id.Equals(node.Me.Id) {
+		t.Errorf("Expected node ID %s, got %s", node.Me.Id, snapshot.Id)
+	}
+	if len(snapshot.Contacts) != 1 {
+		t.Fatalf("Expected 1 contact, got %d", len(snapshot.Contacts))
+	}
+	restored := snapshot.Contacts[0]
+	if !restored.Id.Equals(contact.Id) || restored.Address() != contact.Address() || len(restored.PublicKey) != 3 {
+		t.Errorf("Expected contact %v, got %v", contact, restored)
+	}
+
+	// The liveness of the contact is saved as well
+	saved := node.RoutingTable.GetContactStats()[0]
+	liveness := restored.ToContact()
+	if !liveness.FirstSeen.Equal(saved.FirstSeen) || !liveness.LastSeen.Equal(saved.LastSeen) ||
+		liveness.LastRTT != 20*time.Millisecond || liveness.SmoothedRTT != 20*time.Millisecond || liveness.Failures != 1 {
+		t.Errorf("Expected liveness %+v, got %+v", saved, liveness)
+	}
+
+	// and kept when the contact is restored
+	restarted := initNodeRT()
+	restarted.RestoreRoutingTable(snapshot)
+	stats := restarted.RoutingTable.GetContactStats()
+	if len(stats) != 1 || !stats[0].FirstSeen.Equal(saved.FirstSeen) || stats[0].SmoothedRTT != saved.SmoothedRTT || stats[0].Failures != 1 {
+		t.Errorf("Expected restored liveness %+v, got %+v", saved, stats)
+	}
+}
+
+func TestLoadInvalidRoutingTableSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := kademlia.LoadRoutingTableSnapshot(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("Expected error for missing snapshot")
+	}
+
+	path := filepath.Join(dir, "invalid.json")
+	os.WriteFile(path, []byte("{"), 0644)
+	if _, err := kademlia.LoadRoutingTableSnapshot(path); err == nil {
+		t.Errorf("Expected error for invalid snapshot")
+	}
+
+	os.WriteFile(path, []byte("{}"), 0644)
+	if _, err := kademlia.LoadRoutingTableSnapshot(path); err == nil {
+		t.Errorf("Expected error for snapshot without node ID")
+	}
+}
+
+func TestRestoreRoutingTable(t *testing.T) {
+	timeout := kademlia.MemoryTimeout
+	kademlia.MemoryTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { kademlia.MemoryTimeout = timeout })
+
+	hub := kademlia.NewMemoryHub()
+	nodes := initMemoryNetwork(t, hub, 5)
+	snapshot := kademlia.NewRoutingTableSnapshot(nodes[0])
+	if len(snapshot.Contacts) != 4 {
+		t.Fatalf("Expected 4 contacts in the snapshot, got %d", len(snapshot.Contacts))
+	}
+
+	// One of the contacts has left the network since the snapshot was taken
+	gone := nodes[4]
+	hub.Unregister(gone.Network.(*kademlia.MemoryNetwork))
+
+	restarted := kademlia.NewMemoryNode(hub, snapshot.Id, 5, 3)
+	contacts := restarted.RestoreRoutingTable(snapshot)
+	if len(contacts) != 4 {
+		t.Fatalf("Expected 4 restored contacts, got %d", len(contacts))
+	}
+	if !hasContact(restarted, gone.Me.Id.String()) {
+		t.Errorf("Expected restored contacts to be usable before they are verified")
+	}
+
+	restarted.VerifyContacts(contacts)
+	if hasContact(restarted, gone.Me.Id.String()) {
+		t.Errorf("Expected contact %s that left to be removed", gone.Me.Id)
+	}
+	for _, node := range nodes[1:4] {
+		if !hasContact(restarted, node.Me.Id.String()) {
+			t.Errorf("Expected live contact %s to be kept", node.Me.Id)
+		}
+	}
+}
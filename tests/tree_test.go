@@ -0,0 +1,154 @@
+package tests
+
+import (
+	kademlia "kadlab-group-6/pkg/kademlia_node"
+	mocks "kadlab-group-6/pkg/mocks"
+	"testing"
+)
+
+// initTreeNode creates a node with a bucket splitting routing table
+func initTreeNode(k int, relaxed bool) *kademlia.Node {
+	kademlia.BucketSplitting = true
+	kademlia.RelaxedSplitting = relaxed
+	defer func() {
+		kademlia.BucketSplitting = false
+		kademlia.RelaxedSplitting = false
+	}()
+
+	node := &kademlia.Node{
+		K:  k,
+		Me: kademlia.NewContact(kademlia.NewKademliaID("0000000000000000000000000000000000000000"), "", 0),
+	}
+	node.RoutingTable = kademlia.NewRoutingTable(node)
+	node.MessageHandler = mocks.NewMockMessageHandler(node)
+	node.Network = mocks.NewMockNetwork(node)
+	return node
+}
+
+func addContacts(node *kademlia.Node, ids ...string) {
+	for _, id := range ids {
+		node.RoutingTable.AddContact(&kademlia.Contact{Id: kademlia.NewKademliaID(id)})
+	}
+	fixedTable(node).WaitForEvictions()
+}
+
+func TestTreeRoutingTableStartsWithOneBucket(t *testing.T) {
+	node := initTreeNode(20, false)
+	if node.RoutingTable.(*kademlia.RoutingTable).Buckets != nil {
+		t.Errorf("Expected no fixed buckets")
+	}
+	if stats := node.RoutingTable.GetBucketStats(); len(stats) != 1 || stats[0].Prefix != "" {
+		t.Errorf("Expected 1 bucket with an empty prefix, got %v", stats)
+	}
+}
+
+func TestTreeRoutingTableSplitsOwnBucket(t *testing.T) {
+	node := initTreeNode(2, false)
+	addContacts(node,
+		"8000000000000000000000000000000000000000",
+		"8100000000000000000000000000000000000000",
+		"4000000000000000000000000000000000000000")
+
+	stats := node.RoutingTable.GetBucketStats()
+	if len(stats) != 2 {
+		t.Fatalf("Expected the bucket covering our own ID to be split into 2, got %d", len(stats))
+	}
+	if stats[0].Prefix != "0" || stats[0].Contacts != 1 || stats[1].Prefix != "1" || stats[1].Contacts != 2 {
+		t.Errorf("Expected 1 and 2 contacts in the buckets 0 and 1, got %v", stats)
+	}
+
+	// The bucket not covering our own ID is not split
+	addContacts(node, "8200000000000000000000000000000000000000")
+	stats = node.RoutingTable.GetBucketStats()
+	if len(stats) != 2 {
+		t.Errorf("Expected 2 buckets, got %d", len(stats))
+	}
+	if stats[1].Replacements != 1 || hasContact(node, "8200000000000000000000000000000000000000") {
+		t.Errorf("Expected the new contact to be a replacement, got %v", stats)
+	}
+}
+
+func TestTreeRoutingTableSplitsRepeatedly(t *testing.T) {
+	node := initTreeNode(2, false)
+	// All contacts share the first 7 bits with our own ID
+	addContacts(node,
+		"0100000000000000000000000000000000000000",
+		"0180000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000001")
+
+	stats := node.RoutingTable.GetBucketStats()
+	if len(stats) != 9 {
+		t.Errorf("Expected 9 buckets after splitting down to the 8th bit, got %d", len(stats))
+	}
+	contacts := node.RoutingTable.FindClosestContacts(node.Me.Id)
+	if len(contacts) != 2 || !contacts[0].Id.Equals(kademlia.NewKademliaID("0000000000000000000000000000000000000001")) {
+		t.Errorf("Expected the 2 closest contacts, got %v", contacts)
+	}
+}
+
+func TestTreeRoutingTableRelaxedSplitting(t *testing.T) {
+	ids := []string{
+		"c000000000000000000000000000000000000000",
+		"c100000000000000000000000000000000000000",
+		"8000000000000000000000000000000000000000",
+	}
+	closest := kademlia.Contact{Id: kademlia.NewKademliaID(ids[2])}
+
+	strict := initTreeNode(2, false)
+	addContacts(strict, ids...)
+	if len(strict.RoutingTable.GetBucketStats()) != 2 {
+		t.Errorf("Expected 2 buckets without relaxed splitting, got %d", len(strict.RoutingTable.GetBucketStats()))
+	}
+	if contacts := strict.RoutingTable.FindClosestContacts(closest.Id); contacts[0].Id.Equals(closest.Id) {
+		t.Errorf("Expected contact %s to not be in the routing table", closest.Id)
+	}
+
+	// The closest contact keeps a bucket of its own
+	relaxed := initTreeNode(2, true)
+	addContacts(relaxed, ids...)
+	if len(relaxed.RoutingTable.GetBucketStats()) != 3 {
+		t.Errorf("Expected 3 buckets with relaxed splitting, got %d", len(relaxed.RoutingTable.GetBucketStats()))
+	}
+	if contacts := relaxed.RoutingTable.FindClosestContacts(closest.Id); !contacts[0].Id.Equals(closest.Id) {
+		t.Errorf("Expected contact %s to be in the routing table", closest.Id)
+	}
+}
+
+func TestTreeRoutingTableEviction(t *testing.T) {
+	node := initTreeNode(2, false)
+	node.MessageHandler = mocks.NewMockMessageHandlerError(node)
+	addContacts(node,
+		"8000000000000000000000000000000000000000",
+		"8100000000000000000000000000000000000000",
+		"4000000000000000000000000000000000000000",
+		"8200000000000000000000000000000000000000")
+
+	if hasContact(node, "8000000000000000000000000000000000000000") {
+		t.Errorf("Expected the least recently seen contact to be evicted")
+	}
+	if !hasContact(node, "8200000000000000000000000000000000000000") {
+		t.Errorf("Expected the new contact to be promoted")
+	}
+}
+
+func TestTreeRoutingTableLookup(t *testing.T) {
+	kademlia.BucketSplitting = true
+	defer func() { kademlia.BucketSplitting = false }()
+	hub := kademlia.NewMemoryHub()
+	nodes := initMemoryNetwork(t, hub, 20)
+
+	target := nodes[len(nodes)-1].Me
+	contacts := nodes[1].LookupContact(kademlia.NewContact(target.Id, "", 0))
+	found := false
+	for _, contact := range contacts {
+		if contact.Id.Equals(target.Id) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected lookup to find %s, got %v", target.Id, contacts)
+	}
+	if len(nodes[0].RoutingTable.GetBucketStats()) < 2 {
+		t.Errorf("Expected the routing table of the bootstrap node to have been split")
+	}
+}